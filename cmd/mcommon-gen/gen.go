@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/schemalex/schemalex"
+	"github.com/schemalex/schemalex/model"
+)
+
+// genAcronyms 需要全大写的单词
+var genAcronyms = map[string]bool{
+	"id":   true,
+	"ip":   true,
+	"url":  true,
+	"uid":  true,
+	"uuid": true,
+	"api":  true,
+	"json": true,
+	"html": true,
+	"http": true,
+	"sql":  true,
+}
+
+// genColumn 列
+type genColumn struct {
+	Name    string
+	Field   string
+	Arg     string
+	Type    string
+	ArgType string
+	Comment string
+	AutoInc bool
+	// InsertSkip Insert 时不写入 由数据库默认值填充
+	InsertSkip bool
+
+	argImport string
+}
+
+// genIndex 索引查询函数
+type genIndex struct {
+	Name    string
+	Func    string
+	Columns []*genColumn
+}
+
+// genTable 表
+type genTable struct {
+	Name    string
+	Struct  string
+	Columns []*genColumn
+	PK      []*genColumn
+	Indexes []*genIndex
+	// InsertSkip Insert 时不写入的列
+	InsertSkip []*genColumn
+}
+
+// genFile 生成的文件
+type genFile struct {
+	Package string
+	Tables  []*genTable
+	Imports []string
+	Libs    []string
+}
+
+// generate 根据建表sql生成代码 insertSkip 为 Insert 时不写入的列名
+func generate(src []byte, pkg string, insertSkip []string) ([]byte, error) {
+	stmts, err := schemalex.New().Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	file := &genFile{
+		Package: pkg,
+	}
+	imports := map[string]bool{
+		"context": true,
+	}
+	skipMap := map[string]bool{}
+	for _, name := range insertSkip {
+		skipMap[name] = true
+	}
+	for _, stmt := range stmts {
+		table, ok := stmt.(model.Table)
+		if !ok {
+			continue
+		}
+		table, _ = table.Normalize()
+		t, err := genBuildTable(table, imports, skipMap)
+		if err != nil {
+			return nil, err
+		}
+		file.Tables = append(file.Tables, t)
+	}
+	for k := range imports {
+		file.Imports = append(file.Imports, k)
+	}
+	sort.Strings(file.Imports)
+	file.Libs = []string{"github.com/moremorefun/mcommon"}
+
+	buf := new(bytes.Buffer)
+	err = genTemplate.Execute(buf, file)
+	if err != nil {
+		return nil, err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format error: %w\n%s", err, buf.String())
+	}
+	return code, nil
+}
+
+// genBuildTable 解析表结构
+func genBuildTable(table model.Table, imports map[string]bool, insertSkip map[string]bool) (*genTable, error) {
+	t := &genTable{
+		Name:   table.Name(),
+		Struct: genCamel(table.Name()),
+	}
+	columnMap := map[string]*genColumn{}
+	for col := range table.Columns() {
+		goType, importPath := genGoType(col, true)
+		if importPath != "" {
+			imports[importPath] = true
+		}
+		argType, argImport := genGoType(col, false)
+		c := &genColumn{
+			Name:       col.Name(),
+			Field:      genCamel(col.Name()),
+			Arg:        genLowerCamel(col.Name()),
+			Type:       goType,
+			ArgType:    argType,
+			argImport:  argImport,
+			AutoInc:    col.IsAutoIncrement(),
+			InsertSkip: insertSkip[col.Name()],
+		}
+		if col.HasComment() {
+			c.Comment = strings.Replace(col.Comment(), "\n", " ", -1)
+		}
+		t.Columns = append(t.Columns, c)
+		columnMap[c.Name] = c
+		if c.InsertSkip {
+			t.InsertSkip = append(t.InsertSkip, c)
+		}
+	}
+	funcNames := map[string]bool{}
+	for index := range table.Indexes() {
+		var columns []*genColumn
+		var names []string
+		for ic := range index.Columns() {
+			c, ok := columnMap[ic.Name()]
+			if !ok {
+				return nil, fmt.Errorf("table %s index column not exist: %s", t.Name, ic.Name())
+			}
+			columns = append(columns, c)
+			names = append(names, c.Field)
+		}
+		if index.IsPrimaryKey() {
+			t.PK = columns
+			genAddArgImports(columns, imports)
+			continue
+		}
+		if !index.IsNormal() && !index.IsUnique() {
+			continue
+		}
+		funcName := "SelectBy" + strings.Join(names, "")
+		if funcNames[funcName] {
+			continue
+		}
+		funcNames[funcName] = true
+		genAddArgImports(columns, imports)
+		t.Indexes = append(t.Indexes, &genIndex{
+			Name:    index.Name(),
+			Func:    funcName,
+			Columns: columns,
+		})
+	}
+	return t, nil
+}
+
+// genAddArgImports 添加函数参数需要导入的包
+func genAddArgImports(columns []*genColumn, imports map[string]bool) {
+	for _, c := range columns {
+		if c.argImport != "" {
+			imports[c.argImport] = true
+		}
+	}
+}
+
+// genGoType 获取列对应的go类型和需要导入的包 withNull 为false时不使用sql.Null类型
+func genGoType(col model.TableColumn, withNull bool) (string, string) {
+	null := withNull && col.NullState() != model.NullStateNotNull && !col.IsPrimary()
+	switch col.Type() {
+	case model.ColumnTypeTinyInt, model.ColumnTypeSmallInt, model.ColumnTypeMediumInt,
+		model.ColumnTypeInt, model.ColumnTypeInteger, model.ColumnTypeBigInt, model.ColumnTypeYear:
+		if null {
+			return "sql.NullInt64", "database/sql"
+		}
+		return "int64", ""
+	case model.ColumnTypeBoolean, model.ColumnTypeBool:
+		if null {
+			return "sql.NullBool", "database/sql"
+		}
+		return "bool", ""
+	case model.ColumnTypeReal, model.ColumnTypeDouble, model.ColumnTypeFloat:
+		if null {
+			return "sql.NullFloat64", "database/sql"
+		}
+		return "float64", ""
+	case model.ColumnTypeDate, model.ColumnTypeDateTime, model.ColumnTypeTimestamp:
+		// 需要在dsn中设置 parseTime=true
+		if null {
+			return "sql.NullTime", "database/sql"
+		}
+		return "time.Time", "time"
+	case model.ColumnTypeBit, model.ColumnTypeBinary, model.ColumnTypeVarBinary,
+		model.ColumnTypeTinyBlob, model.ColumnTypeBlob, model.ColumnTypeMediumBlob, model.ColumnTypeLongBlob:
+		return "[]byte", ""
+	default:
+		// decimal 使用字符串保留精度
+		if null {
+			return "sql.NullString", "database/sql"
+		}
+		return "string", ""
+	}
+}
+
+// genCamel 下划线转大驼峰
+func genCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		lower := strings.ToLower(part)
+		if genAcronyms[lower] {
+			parts[i] = strings.ToUpper(lower)
+			continue
+		}
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// genLowerCamel 下划线转小驼峰
+func genLowerCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		lower := strings.ToLower(part)
+		if i == 0 {
+			parts[i] = lower
+			continue
+		}
+		if genAcronyms[lower] {
+			parts[i] = strings.ToUpper(lower)
+			continue
+		}
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	arg := strings.Join(parts, "")
+	switch arg {
+	case "type", "func", "range", "map", "select", "default", "package", "import", "interface", "go", "chan", "var", "const", "return", "case", "struct", "switch", "for", "if", "else", "break", "continue", "defer", "goto", "fallthrough",
+		"ctx", "tx", "row", "rows", "opt", "err", "ok", "updateMap":
+		arg += "Arg"
+	}
+	return arg
+}
+
+// genTemplate 代码模板
+var genTemplate = template.Must(template.New("gen").Parse(`// Code generated by mcommon-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+
+{{- range .Libs}}
+
+	"{{.}}"
+{{- end}}
+)
+{{range $t := .Tables}}
+// T{{$t.Struct}} {{$t.Name}}
+const T{{$t.Struct}} = "{{$t.Name}}"
+
+// {{$t.Struct}} {{$t.Name}}
+type {{$t.Struct}} struct {
+{{- range $t.Columns}}
+	{{.Field}} {{.Type}} ` + "`" + `db:"{{.Name}}" json:"{{.Name}}"` + "`" + `{{if .Comment}} // {{.Comment}}{{end}}
+{{- end}}
+}
+{{if $t.PK}}
+// {{$t.Struct}}GetByPK 根据主键获取 不存在时返回nil
+func {{$t.Struct}}GetByPK(ctx context.Context, tx mcommon.DbExeAble{{range $t.PK}}, {{.Arg}} {{.ArgType}}{{end}}) (*{{$t.Struct}}, error) {
+	var row {{$t.Struct}}
+	ok, err := mcommon.DbGetKV(
+		ctx,
+		tx,
+		&row,
+		T{{$t.Struct}},
+		[]string{ {{- range $i, $c := $t.PK}}{{if $i}}, {{end}}"{{$c.Name}}"{{end -}} },
+		[]interface{}{ {{- range $i, $c := $t.PK}}{{if $i}}, {{end}}{{$c.Arg}}{{end -}} },
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &row, nil
+}
+{{end}}
+{{- range $index := $t.Indexes}}
+// {{$t.Struct}}{{$index.Func}} 根据索引 {{$index.Name}} 查询
+func {{$t.Struct}}{{$index.Func}}(ctx context.Context, tx mcommon.DbExeAble{{range $index.Columns}}, {{.Arg}} {{.ArgType}}{{end}}, opt *mcommon.DbSelectOpt) ([]*{{$t.Struct}}, error) {
+	var rows []*{{$t.Struct}}
+	err := mcommon.DbSelectKV(
+		ctx,
+		tx,
+		&rows,
+		T{{$t.Struct}},
+		[]string{ {{- range $i, $c := $index.Columns}}{{if $i}}, {{end}}"{{$c.Name}}"{{end -}} },
+		[]interface{}{ {{- range $i, $c := $index.Columns}}{{if $i}}, {{end}}{{$c.Arg}}{{end -}} },
+		opt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+{{end}}
+// {{$t.Struct}}Insert 插入 自增列{{if $t.InsertSkip}}和 {{range $i, $c := $t.InsertSkip}}{{if $i}}, {{end}}{{$c.Name}}{{end}} {{end}}不写入 返回lastID
+func {{$t.Struct}}Insert(ctx context.Context, tx mcommon.DbExeAble, row *{{$t.Struct}}) (int64, error) {
+	return mcommon.DbInsertKV(
+		ctx,
+		tx,
+		T{{$t.Struct}},
+		mcommon.H{
+{{- range $t.Columns}}{{if not (or .AutoInc .InsertSkip)}}
+			"{{.Name}}": row.{{.Field}},
+{{- end}}{{end}}
+		},
+	)
+}
+{{if $t.PK}}
+// {{$t.Struct}}UpdateByPK 根据主键更新 返回影响行数
+func {{$t.Struct}}UpdateByPK(ctx context.Context, tx mcommon.DbExeAble{{range $t.PK}}, {{.Arg}} {{.ArgType}}{{end}}, updateMap mcommon.H) (int64, error) {
+	return mcommon.DbUpdateKV(
+		ctx,
+		tx,
+		T{{$t.Struct}},
+		updateMap,
+		[]string{ {{- range $i, $c := $t.PK}}{{if $i}}, {{end}}"{{$c.Name}}"{{end -}} },
+		[]interface{}{ {{- range $i, $c := $t.PK}}{{if $i}}, {{end}}{{$c.Arg}}{{end -}} },
+	)
+}
+
+// {{$t.Struct}}DeleteByPK 根据主键删除 返回影响行数
+func {{$t.Struct}}DeleteByPK(ctx context.Context, tx mcommon.DbExeAble{{range $t.PK}}, {{.Arg}} {{.ArgType}}{{end}}) (int64, error) {
+	return mcommon.DbDeleteKV(
+		ctx,
+		tx,
+		T{{$t.Struct}},
+		[]string{ {{- range $i, $c := $t.PK}}{{if $i}}, {{end}}"{{$c.Name}}"{{end -}} },
+		[]interface{}{ {{- range $i, $c := $t.PK}}{{if $i}}, {{end}}{{$c.Arg}}{{end -}} },
+	)
+}
+{{end -}}
+{{end -}}
+`))
@@ -0,0 +1,50 @@
+// mcommon-gen 根据建表sql文件生成数据结构和数据库操作函数
+//
+//	mcommon-gen -sql schema.sql -pkg model -o model/model_gen.go
+//
+// 由数据库默认值填充的列可以用 -insert-skip 在 Insert 中跳过 如 -insert-skip created_at,updated_at
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	sqlFilePath := flag.String("sql", "", "建表sql文件")
+	pkg := flag.String("pkg", "model", "生成代码的包名")
+	out := flag.String("o", "", "输出文件 为空时输出到标准输出")
+	insertSkip := flag.String("insert-skip", "", "Insert 时不写入的列 逗号分隔")
+	flag.Parse()
+
+	if *sqlFilePath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	src, err := ioutil.ReadFile(*sqlFilePath)
+	if err != nil {
+		log.Fatalf("read sql error: [%T] %s", err, err.Error())
+	}
+	var skip []string
+	for _, name := range strings.Split(*insertSkip, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			skip = append(skip, name)
+		}
+	}
+	code, err := generate(src, *pkg, skip)
+	if err != nil {
+		log.Fatalf("generate error: [%T] %s", err, err.Error())
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(code)
+	} else {
+		err = ioutil.WriteFile(*out, code, 0644)
+	}
+	if err != nil {
+		log.Fatalf("write error: [%T] %s", err, err.Error())
+	}
+}
@@ -0,0 +1,18 @@
+CREATE TABLE `t_user` (
+  `id` BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+  `user_name` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '用户名',
+  `type` INT(11) NOT NULL DEFAULT '0',
+  `score` DECIMAL(10,2) NOT NULL DEFAULT '0.00',
+  `deleted_at` DATETIME NULL DEFAULT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_name` (`user_name`),
+  KEY `type` (`type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `t_user_tag` (
+  `uid` BIGINT(20) NOT NULL,
+  `tag` VARCHAR(32) NOT NULL,
+  `data` JSON NULL,
+  PRIMARY KEY (`uid`, `tag`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
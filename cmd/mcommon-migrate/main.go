@@ -0,0 +1,103 @@
+// mcommon-migrate 执行版本迁移
+//
+//	mcommon-migrate -dsn "user:password@tcp(127.0.0.1:3306)/db" -dir migrations up
+//	mcommon-migrate -dsn ... -dir migrations down 1
+//	mcommon-migrate -dsn ... -dir migrations status
+//	mcommon-migrate -dsn ... -dir migrations baseline schema.sql t_user t_order
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/moremorefun/mcommon"
+)
+
+func main() {
+	dsn := flag.String("dsn", "", "数据源")
+	dir := flag.String("dir", "migrations", "迁移目录")
+	table := flag.String("table", mcommon.DbMigrationTable, "迁移记录表名")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] up | down [n] | status | baseline sql_file table...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if *dsn == "" || len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	db, err := mcommon.DbOpen(ctx, mcommon.DbOptions{
+		DSN: *dsn,
+	})
+	if err != nil {
+		log.Fatalf("db open error: [%T] %s", err, err.Error())
+	}
+	defer db.Close()
+	migrator := mcommon.DbMigratorCreate(db, *dir, &mcommon.DbMigratorOpt{
+		Table: *table,
+	})
+
+	switch args[0] {
+	case "up":
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalf("up error: [%T] %s", err, err.Error())
+		}
+		log.Printf("applied %d migrations", n)
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil {
+				log.Fatalf("down n error: [%T] %s", err, err.Error())
+			}
+		}
+		n, err = migrator.Down(ctx, n)
+		if err != nil {
+			log.Fatalf("down error: [%T] %s", err, err.Error())
+		}
+		log.Printf("rolled back %d migrations", n)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("status error: [%T] %s", err, err.Error())
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + time.Unix(0, status.AppliedAt*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+			}
+			if status.Modified {
+				state += " modified"
+			}
+			if status.Missing {
+				state += " missing"
+			}
+			if status.Dirty {
+				state += " dirty"
+			}
+			fmt.Printf("%d\t%s\t%s\n", status.Version, status.Name, state)
+		}
+	case "baseline":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		filePath, err := migrator.Baseline(ctx, args[2:], args[1])
+		if err != nil {
+			log.Fatalf("baseline error: [%T] %s", err, err.Error())
+		}
+		log.Printf("created %s", filePath)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -3,55 +3,197 @@ package mcommon
 import (
 	"bytes"
 	"context"
-	"io/ioutil"
+	"errors"
+	"path"
 	"regexp"
-	"strings"
+	"sort"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/schemalex/schemalex"
 	"github.com/schemalex/schemalex/diff"
+	"github.com/schemalex/schemalex/model"
 )
 
+// DbErrNoSuchTable 表不存在
+const DbErrNoSuchTable = 1146
+
+// dbStructIncRe 建表语句中的 AUTO_INCREMENT
+var dbStructIncRe = regexp.MustCompile(`AUTO_INCREMENT\s*=\s*(\d)*\s*,`)
+
+// DbStructDiffOpt 全库对比选项
+type DbStructDiffOpt struct {
+	// Include 包含的表名 支持 path.Match 通配符 为空时包含所有表
+	Include []string
+	// Exclude 排除的表名 支持 path.Match 通配符
+	Exclude []string
+	// WithDrop 是否生成删除多余表的 DROP TABLE
+	WithDrop bool
+}
+
+// DbStructDiff 全库对比结果
+type DbStructDiff struct {
+	// SQL 更新指令
+	SQL string
+	// ExtraTables 数据库中存在 sql文件中不存在的表
+	ExtraTables []string
+}
+
 // DbStructGetDiff 获取数据库更新指令
 func DbStructGetDiff(tx DbExeAble, tableNames []string, sqlFilePath string) (string, error) {
-	var dbSQLs []string
+	return DbStructGetDiffSource(tx, tableNames, DbStructFiles(sqlFilePath))
+}
+
+// DbStructGetDiffSource 获取数据库更新指令 目标表结构来自 source
+func DbStructGetDiffSource(tx DbExeAble, tableNames []string, source DbStructSource) (string, error) {
+	dbSQL, err := dbStructShowCreate(context.Background(), tx, tableNames)
+	if err != nil {
+		return "", err
+	}
+	// 目的sql
+	to, err := dbStructLoad(source)
+	if err != nil {
+		return "", err
+	}
+	from, err := schemalex.New().ParseString(dbSQL)
+	if err != nil {
+		return "", err
+	}
+	sqlDiff := new(bytes.Buffer)
+	err = diff.Statements(sqlDiff, from, to, diff.WithTransaction(true))
+	if err != nil {
+		return "", err
+	}
+	// 替换 AUTO_INCREMENT
+	return dbStructIncRe.ReplaceAllString(sqlDiff.String(), ""), nil
+}
+
+// DbStructGetDiffAll 获取数据库中的表(不包括视图) 与sql文件整体对比
+// 数据库中多余的表单独返回 opt.WithDrop 为true时同时生成 DROP TABLE
+func DbStructGetDiffAll(ctx context.Context, tx DbExeAble, sqlFilePath string, opt *DbStructDiffOpt) (*DbStructDiff, error) {
+	return DbStructGetDiffAllSource(ctx, tx, DbStructFiles(sqlFilePath), opt)
+}
+
+// DbStructGetDiffAllSource 与 DbStructGetDiffAll 相同 目标表结构来自 source
+func DbStructGetDiffAllSource(ctx context.Context, tx DbExeAble, source DbStructSource, opt *DbStructDiffOpt) (*DbStructDiff, error) {
+	if opt == nil {
+		opt = &DbStructDiffOpt{}
+	}
+	toStmts, err := dbStructLoad(source)
+	if err != nil {
+		return nil, err
+	}
+	var to model.Stmts
+	fileTables := map[string]bool{}
+	for _, stmt := range toStmts {
+		table, ok := stmt.(model.Table)
+		if !ok {
+			to = append(to, stmt)
+			continue
+		}
+		if !dbStructMatch(table.Name(), opt) {
+			continue
+		}
+		fileTables[table.Name()] = true
+		to = append(to, stmt)
+	}
+
+	var dbTables []string
+	err = DbSelectNamedContent(
+		ctx,
+		tx,
+		&dbTables,
+		`SELECT
+	TABLE_NAME
+FROM
+	information_schema.TABLES
+WHERE
+	TABLE_SCHEMA=DATABASE()
+	AND TABLE_TYPE='BASE TABLE'
+ORDER BY
+	TABLE_NAME`,
+		gin.H{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	ret := &DbStructDiff{}
+	var tableNames []string
+	for _, tableName := range dbTables {
+		if !dbStructMatch(tableName, opt) {
+			continue
+		}
+		if !fileTables[tableName] {
+			ret.ExtraTables = append(ret.ExtraTables, tableName)
+			if !opt.WithDrop {
+				continue
+			}
+		}
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(ret.ExtraTables)
+
+	dbSQL, err := dbStructShowCreate(ctx, tx, tableNames)
+	if err != nil {
+		return nil, err
+	}
+	from, err := schemalex.New().ParseString(dbSQL)
+	if err != nil {
+		return nil, err
+	}
+	sqlDiff := new(bytes.Buffer)
+	err = diff.Statements(sqlDiff, from, to, diff.WithTransaction(true))
+	if err != nil {
+		return nil, err
+	}
+	ret.SQL = dbStructIncRe.ReplaceAllString(sqlDiff.String(), "")
+	return ret, nil
+}
+
+// dbStructShowCreate 获取表的建表语句 不存在的表跳过
+func dbStructShowCreate(ctx context.Context, tx DbExeAble, tableNames []string) (string, error) {
+	var dbSQLs bytes.Buffer
 	for _, tableName := range tableNames {
 		var row struct {
 			TableName string `db:"Table"`
 			TableSQL  string `db:"Create Table"`
 		}
 		ok, err := DbGetNamedContent(
-			context.Background(),
+			ctx,
 			tx,
 			&row,
 			`SHOW CREATE TABLE `+tableName,
 			gin.H{},
 		)
 		if err != nil {
-			if strings.Contains(err.Error(), "doesn't exist") {
+			var mysqlErr *mysql.MySQLError
+			if errors.As(err, &mysqlErr) && mysqlErr.Number == DbErrNoSuchTable {
 				continue
 			}
 			return "", err
 		}
 		if ok {
-			dbSQLs = append(dbSQLs, row.TableSQL+";")
+			dbSQLs.WriteString(row.TableSQL)
+			dbSQLs.WriteString(";\n")
 		}
 	}
-	// 原始sql
-	dbSQL := strings.Join(dbSQLs, "\n")
-	// 目的sql
-	toSQL, err := ioutil.ReadFile(sqlFilePath)
-	if err != nil {
-		return "", err
+	return dbSQLs.String(), nil
+}
+
+// dbStructMatch 表名是否符合包含和排除规则
+func dbStructMatch(tableName string, opt *DbStructDiffOpt) bool {
+	for _, pattern := range opt.Exclude {
+		if ok, _ := path.Match(pattern, tableName); ok {
+			return false
+		}
 	}
-	sqlDiff := new(bytes.Buffer)
-	err = diff.Strings(sqlDiff, dbSQL, string(toSQL), diff.WithTransaction(true))
-	if err != nil {
-		return "", err
+	if len(opt.Include) == 0 {
+		return true
 	}
-	// 替换 AUTO_INCREMENT
-	r, _ := regexp.Compile(`AUTO_INCREMENT\s*=\s*(\d)*\s*,`)
-	sqlDiffWithoutInc := r.ReplaceAllStringFunc(sqlDiff.String(), func(s string) string {
-		return ""
-	})
-	return sqlDiffWithoutInc, nil
+	for _, pattern := range opt.Include {
+		if ok, _ := path.Match(pattern, tableName); ok {
+			return true
+		}
+	}
+	return false
 }
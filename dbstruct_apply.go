@@ -0,0 +1,231 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// DbStructOther 其他语句 如 SET
+	DbStructOther = iota
+	// DbStructAdditive 新增 CREATE TABLE ADD COLUMN ADD INDEX
+	DbStructAdditive
+	// DbStructChange 修改小表的列 MODIFY CHANGE
+	DbStructChange
+	// DbStructSlow 修改行数超过 SlowRows 的表的列 可能长时间锁表
+	DbStructSlow
+	// DbStructDestructive 删除 DROP TABLE DROP COLUMN DROP INDEX
+	DbStructDestructive
+)
+
+// dbStructKindNames 语句类型名
+var dbStructKindNames = map[int]string{
+	DbStructOther:       "other",
+	DbStructAdditive:    "additive",
+	DbStructChange:      "change",
+	DbStructSlow:        "slow",
+	DbStructDestructive: "destructive",
+}
+
+// dbStructTableRe 语句中的表名
+var dbStructTableRe = regexp.MustCompile("(?i)^(?:ALTER|DROP|CREATE)\\s+TABLE\\s+(?:IF\\s+(?:NOT\\s+)?EXISTS\\s+)?`?([\\w.]+)`?")
+
+// dbStructIdentRe 反引号中的标识符
+var dbStructIdentRe = regexp.MustCompile("`[^`]*`")
+
+// dbStructDropRe ALTER TABLE 中的删除
+var dbStructDropRe = regexp.MustCompile(`(?i)(^|,|\s)DROP\s`)
+
+// dbStructModifyRe ALTER TABLE 中的修改
+var dbStructModifyRe = regexp.MustCompile(`(?i)(^|,|\s)(MODIFY|CHANGE|CONVERT\s+TO)\s`)
+
+// ErrDbStructRefused 包含策略不允许的语句
+var ErrDbStructRefused = errors.New("db struct refused")
+
+// DbStructPolicy 执行策略
+type DbStructPolicy struct {
+	// AllowSlow 是否允许执行 DbStructSlow 语句
+	AllowSlow bool
+	// AllowDestructive 是否允许执行 DbStructDestructive 语句
+	AllowDestructive bool
+	// SlowRows 修改列时视为大表的预估行数 为0时为100万
+	SlowRows int64
+	// DryRun 只打印执行计划 不执行
+	DryRun bool
+}
+
+// DbStructStatement 执行计划中的一条语句
+type DbStructStatement struct {
+	SQL   string `json:"sql"`
+	Table string `json:"table"`
+	Kind  int    `json:"kind"`
+	// Rows information_schema 中的预估行数
+	Rows int64 `json:"rows"`
+	// Duration 执行耗时 毫秒
+	Duration float64 `json:"duration"`
+}
+
+// KindName 语句类型名
+func (s *DbStructStatement) KindName() string {
+	return dbStructKindNames[s.Kind]
+}
+
+// DbStructPlan 拆分 DbStructGetDiff 的结果并对每条语句分类
+func DbStructPlan(ctx context.Context, tx DbExeAble, diff string, policy *DbStructPolicy) ([]*DbStructStatement, error) {
+	if policy == nil {
+		policy = &DbStructPolicy{}
+	}
+	slowRows := policy.SlowRows
+	if slowRows <= 0 {
+		slowRows = 1000000
+	}
+	rowsMap := map[string]int64{}
+	var stmts []*DbStructStatement
+	for _, query := range dbSQLSplit(diff) {
+		upper := strings.ToUpper(query)
+		if upper == "BEGIN" || upper == "COMMIT" {
+			// ddl会隐式提交 事物没有作用
+			continue
+		}
+		stmt := &DbStructStatement{
+			SQL: query,
+		}
+		alter := query
+		matches := dbStructTableRe.FindStringSubmatch(query)
+		if matches != nil {
+			stmt.Table = matches[1]
+			alter = query[len(matches[0]):]
+		}
+		// 去掉字符串和标识符 避免 COMMENT 或列名中的关键字被误判
+		alter = dbStructIdentRe.ReplaceAllString(dbStatLiteral(alter), "?")
+		switch {
+		case strings.HasPrefix(upper, "CREATE TABLE"):
+			stmt.Kind = DbStructAdditive
+		case strings.HasPrefix(upper, "DROP TABLE"):
+			stmt.Kind = DbStructDestructive
+		case strings.HasPrefix(upper, "ALTER TABLE"):
+			switch {
+			case dbStructDropRe.MatchString(alter):
+				stmt.Kind = DbStructDestructive
+			case dbStructModifyRe.MatchString(alter):
+				rows, ok := rowsMap[stmt.Table]
+				if !ok {
+					var err error
+					rows, err = dbStructTableRows(ctx, tx, stmt.Table)
+					if err != nil {
+						return nil, err
+					}
+					rowsMap[stmt.Table] = rows
+				}
+				stmt.Rows = rows
+				stmt.Kind = DbStructChange
+				if rows >= slowRows {
+					stmt.Kind = DbStructSlow
+				}
+			default:
+				stmt.Kind = DbStructAdditive
+			}
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// DbStructApply 按策略逐条执行 DbStructGetDiff 的结果 并打印每条语句的耗时
+// 包含策略不允许的语句时不执行任何语句 返回 ErrDbStructRefused
+func DbStructApply(ctx context.Context, db *sqlx.DB, diff string, policy *DbStructPolicy) ([]*DbStructStatement, error) {
+	if policy == nil {
+		policy = &DbStructPolicy{}
+	}
+	stmts, err := DbStructPlan(ctx, db, diff, policy)
+	if err != nil {
+		return nil, err
+	}
+	var refused []string
+	for _, stmt := range stmts {
+		if (stmt.Kind == DbStructSlow && !policy.AllowSlow) ||
+			(stmt.Kind == DbStructDestructive && !policy.AllowDestructive) {
+			refused = append(refused, fmt.Sprintf("[%s] %s", stmt.KindName(), stmt.SQL))
+		}
+	}
+	if policy.DryRun {
+		for i, stmt := range stmts {
+			Log.Infof("db struct plan %d/%d [%s] rows: %d\n%s", i+1, len(stmts), stmt.KindName(), stmt.Rows, stmt.SQL)
+		}
+		if len(refused) > 0 {
+			Log.Warnf("db struct plan has %d refused statements", len(refused))
+		}
+		return stmts, nil
+	}
+	if len(refused) > 0 {
+		return stmts, fmt.Errorf("%w:\n%s", ErrDbStructRefused, strings.Join(refused, "\n"))
+	}
+
+	// SET FOREIGN_KEY_CHECKS 等需要在同一个连接上执行
+	sqlConn, err := db.Conn(ctx)
+	if err != nil {
+		return stmts, err
+	}
+	defer sqlConn.Close()
+	conn := &dbConn{
+		conn:     sqlConn,
+		mapper:   db.Mapper,
+		bindType: sqlx.BindType(db.DriverName()),
+	}
+	// 中途失败时 SET FOREIGN_KEY_CHECKS = 0 不能随连接回到连接池
+	defer dbConnReset(conn)
+	for i, stmt := range stmts {
+		Log.Infof("db struct apply %d/%d [%s]\n%s", i+1, len(stmts), stmt.KindName(), stmt.SQL)
+		start := time.Now()
+		_, err = dbExec(ctx, conn, stmt.SQL, nil)
+		stmt.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+		if err != nil {
+			return stmts, fmt.Errorf("db struct apply %d/%d error: %w", i+1, len(stmts), err)
+		}
+		Log.Infof("db struct apply %d/%d done in %.2fms", i+1, len(stmts), stmt.Duration)
+	}
+	return stmts, nil
+}
+
+// dbStructTableRows 获取表的预估行数 表不存在时为0
+func dbStructTableRows(ctx context.Context, tx DbExeAble, table string) (int64, error) {
+	var rows struct {
+		TableRows sql.NullInt64 `db:"TABLE_ROWS"`
+	}
+	schema := "DATABASE()"
+	argMap := H{
+		"table_name": table,
+	}
+	if i := strings.Index(table, "."); i >= 0 {
+		schema = ":table_schema"
+		argMap["table_schema"] = table[:i]
+		argMap["table_name"] = table[i+1:]
+	}
+	ok, err := DbGetNamedContent(
+		ctx,
+		tx,
+		&rows,
+		`SELECT
+	TABLE_ROWS
+FROM
+	information_schema.TABLES
+WHERE
+	TABLE_SCHEMA=`+schema+`
+	AND TABLE_NAME=:table_name`,
+		argMap,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+	return rows.TableRows.Int64, nil
+}
@@ -0,0 +1,62 @@
+package mcommon_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moremorefun/mcommon"
+	"github.com/moremorefun/mcommon/dbtest"
+)
+
+func TestDbStructPlan(t *testing.T) {
+	db := dbtest.New()
+	db.Stub(`information_schema.TABLES`).WillReturnRows(mcommon.H{"TABLE_ROWS": int64(2000000)}).Times(1)
+
+	diff := `BEGIN;
+SET FOREIGN_KEY_CHECKS = 0;
+CREATE TABLE t_new (id INT);
+DROP TABLE t_old;
+ALTER TABLE ` + "`t_user`" + ` ADD COLUMN z INT COMMENT 'will drop later', ADD COLUMN ` + "`modify drop`" + ` INT, ADD INDEX idx_z (z);
+ALTER TABLE t_user DROP COLUMN y;
+ALTER TABLE t_user MODIFY COLUMN x BIGINT;
+ALTER TABLE t_user CHANGE COLUMN w w2 INT;
+SET FOREIGN_KEY_CHECKS = 1;
+COMMIT;`
+	stmts, err := mcommon.DbStructPlan(context.Background(), db, diff, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		table string
+		kind  int
+	}{
+		{"", mcommon.DbStructOther},
+		{"t_new", mcommon.DbStructAdditive},
+		{"t_old", mcommon.DbStructDestructive},
+		{"t_user", mcommon.DbStructAdditive},
+		{"t_user", mcommon.DbStructDestructive},
+		{"t_user", mcommon.DbStructSlow},
+		{"t_user", mcommon.DbStructSlow},
+		{"", mcommon.DbStructOther},
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("stmts len: %d", len(stmts))
+	}
+	for i, w := range want {
+		if stmts[i].Table != w.table || stmts[i].Kind != w.kind {
+			t.Errorf("%s: got %s %s", stmts[i].SQL, stmts[i].Table, stmts[i].KindName())
+		}
+	}
+	if stmts[5].Rows != 2000000 {
+		t.Errorf("rows: %d", stmts[5].Rows)
+	}
+
+	db.Stub(`information_schema.TABLES`).WillReturnRows(mcommon.H{"TABLE_ROWS": int64(5)})
+	stmts, err = mcommon.DbStructPlan(context.Background(), db, "ALTER TABLE t_small MODIFY a INT", &mcommon.DbStructPolicy{SlowRows: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 1 || stmts[0].Kind != mcommon.DbStructChange || stmts[0].Rows != 5 {
+		t.Fatalf("stmts: %+v", stmts[0])
+	}
+}
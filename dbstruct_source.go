@@ -0,0 +1,167 @@
+package mcommon
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/schemalex/schemalex"
+	"github.com/schemalex/schemalex/model"
+)
+
+// DbStructFile 建表sql文件
+type DbStructFile struct {
+	Name    string
+	Content string
+}
+
+// DbStructSource 目标表结构的来源
+type DbStructSource interface {
+	Files() ([]DbStructFile, error)
+}
+
+// DbStructSourceFunc 函数形式的来源
+type DbStructSourceFunc func() ([]DbStructFile, error)
+
+// Files 读取文件
+func (f DbStructSourceFunc) Files() ([]DbStructFile, error) {
+	return f()
+}
+
+// DbStructFiles 多个sql文件
+func DbStructFiles(filePaths ...string) DbStructSource {
+	return DbStructSourceFunc(func() ([]DbStructFile, error) {
+		var files []DbStructFile
+		for _, filePath := range filePaths {
+			content, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, DbStructFile{
+				Name:    filePath,
+				Content: string(content),
+			})
+		}
+		return files, nil
+	})
+}
+
+// DbStructGlob 匹配通配符的sql文件 如 schema/*.sql 按文件名排序
+func DbStructGlob(pattern string) DbStructSource {
+	return DbStructSourceFunc(func() ([]DbStructFile, error) {
+		filePaths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(filePaths) == 0 {
+			return nil, fmt.Errorf("schema file miss: %s", pattern)
+		}
+		sort.Strings(filePaths)
+		return DbStructFiles(filePaths...).Files()
+	})
+}
+
+// DbStructReader 从 io.Reader 读取 name 用于错误信息
+// 只在第一次使用时读取 之后使用读取的内容 可以多次使用
+func DbStructReader(name string, r io.Reader) DbStructSource {
+	var once sync.Once
+	var content []byte
+	var err error
+	return DbStructSourceFunc(func() ([]DbStructFile, error) {
+		once.Do(func() {
+			content, err = ioutil.ReadAll(r)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []DbStructFile{
+			{
+				Name:    name,
+				Content: string(content),
+			},
+		}, nil
+	})
+}
+
+// DbStructFileSystem http.FileSystem 目录中的所有 .sql 文件 用于打包到程序中的表结构
+func DbStructFileSystem(fs http.FileSystem, dir string) DbStructSource {
+	return DbStructSourceFunc(func() ([]DbStructFile, error) {
+		d, err := fs.Open(dir)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		infos, err := d.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, info := range infos {
+			if !info.IsDir() && strings.HasSuffix(info.Name(), ".sql") {
+				names = append(names, info.Name())
+			}
+		}
+		sort.Strings(names)
+		var files []DbStructFile
+		for _, name := range names {
+			filePath := path.Join(dir, name)
+			content, err := dbStructReadFS(fs, filePath)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, DbStructFile{
+				Name:    filePath,
+				Content: content,
+			})
+		}
+		return files, nil
+	})
+}
+
+// dbStructReadFS 读取 http.FileSystem 中的文件
+func dbStructReadFS(fs http.FileSystem, filePath string) (string, error) {
+	f, err := fs.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// dbStructLoad 读取来源并合并 同一个表在多个文件中定义时返回错误
+func dbStructLoad(source DbStructSource) (model.Stmts, error) {
+	files, err := source.Files()
+	if err != nil {
+		return nil, err
+	}
+	var stmts model.Stmts
+	tableFiles := map[string]string{}
+	for _, file := range files {
+		fileStmts, err := schemalex.New().ParseString(file.Content)
+		if err != nil {
+			return nil, fmt.Errorf("schema file %s error: %w", file.Name, err)
+		}
+		for _, stmt := range fileStmts {
+			table, ok := stmt.(model.Table)
+			if !ok {
+				continue
+			}
+			if name, ok := tableFiles[table.Name()]; ok {
+				return nil, fmt.Errorf("table %s duplicate in %s and %s", table.Name(), name, file.Name)
+			}
+			tableFiles[table.Name()] = file.Name
+		}
+		stmts = append(stmts, fileStmts...)
+	}
+	return stmts, nil
+}
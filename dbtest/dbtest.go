@@ -0,0 +1,353 @@
+// Package dbtest 提供用于单元测试的内存 mcommon.DbExeAble
+//
+//	db := dbtest.New()
+//	db.Stub(`SELECT .* FROM t_user`).WillReturnRows(User{ID: 1})
+//	db.Stub(`UPDATE t_user`).WillReturnResult(0, 1)
+//	db.Stub(`SELECT .* FROM t_order`).WillReturnRows(mcommon.H{"id": 1}, mcommon.H{"id": 2}) // QueryxContext 同样可用
+//	err := mcommon.DbTransaction(ctx, db, func(dbTx mcommon.DbExeAble) error { ... })
+//	calls := db.Calls()
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/moremorefun/mcommon"
+)
+
+// Call 一次执行记录
+type Call struct {
+	Query string
+	Args  []interface{}
+}
+
+// Stub 预设的执行结果
+type Stub struct {
+	re           *regexp.Regexp
+	rows         []interface{}
+	lastID       int64
+	rowsAffected int64
+	err          error
+	times        int
+	used         int
+}
+
+// DB 内存 DbExeAble 所有执行的语句都会被记录 没有匹配的 Stub 时返回错误
+type DB struct {
+	lock  sync.Mutex
+	stubs []*Stub
+	calls []Call
+
+	// sqlDB 用于 QueryxContext 返回 *sqlx.Rows
+	sqlOnce sync.Once
+	sqlDB   *sqlx.DB
+}
+
+// Tx DB 开启的事物 与 DB 共享记录和 Stub
+type Tx struct {
+	*DB
+	done bool
+}
+
+// New 创建
+func New() *DB {
+	return &DB{}
+}
+
+// Stub 添加预设结果 pattern 为匹配语句的正则 按添加顺序匹配
+func (db *DB) Stub(pattern string) *Stub {
+	s := &Stub{
+		re: regexp.MustCompile(pattern),
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.stubs = append(db.stubs, s)
+	return s
+}
+
+// WillReturnRows 查询返回的行 行为结构体或 mcommon.H
+func (s *Stub) WillReturnRows(rows ...interface{}) *Stub {
+	s.rows = rows
+	return s
+}
+
+// WillReturnResult 执行返回的lastID和影响行数
+func (s *Stub) WillReturnResult(lastID, rowsAffected int64) *Stub {
+	s.lastID = lastID
+	s.rowsAffected = rowsAffected
+	return s
+}
+
+// WillReturnError 返回错误
+func (s *Stub) WillReturnError(err error) *Stub {
+	s.err = err
+	return s
+}
+
+// Times 最多匹配的次数 默认不限制
+func (s *Stub) Times(n int) *Stub {
+	s.times = n
+	return s
+}
+
+// Calls 获取所有执行记录
+func (db *DB) Calls() []Call {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	calls := make([]Call, len(db.calls))
+	copy(calls, db.calls)
+	return calls
+}
+
+// Reset 清空执行记录和 Stub
+func (db *DB) Reset() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.calls = nil
+	db.stubs = nil
+}
+
+// Rebind 不转换
+func (db *DB) Rebind(query string) string {
+	return query
+}
+
+// Get 查询单行
+func (db *DB) Get(dest interface{}, query string, args ...interface{}) error {
+	return db.GetContext(context.Background(), dest, query, args...)
+}
+
+// Exec 执行
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// Select 查询多行
+func (db *DB) Select(dest interface{}, query string, args ...interface{}) error {
+	return db.SelectContext(context.Background(), dest, query, args...)
+}
+
+// GetContext 查询单行 没有行时返回 sql.ErrNoRows
+func (db *DB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	s, err := db.match(query, args)
+	if err != nil {
+		return err
+	}
+	if len(s.rows) == 0 {
+		return sql.ErrNoRows
+	}
+	return assign(reflect.ValueOf(dest), s.rows[0])
+}
+
+// ExecContext 执行
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	s, err := db.match(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return result{lastID: s.lastID, rowsAffected: s.rowsAffected}, nil
+}
+
+// SelectContext 查询多行
+func (db *DB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	s, err := db.match(query, args)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dbtest: dest type error: %T", dest)
+	}
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+	for _, row := range s.rows {
+		elem := reflect.New(elemType)
+		err = assign(elem, row)
+		if err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem.Elem())
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
+
+// DbBeginTx 开启事物 记录 BEGIN
+func (db *DB) DbBeginTx(ctx context.Context, opts *sql.TxOptions) (mcommon.DbTxAble, error) {
+	db.record("BEGIN", nil)
+	return &Tx{DB: db}, nil
+}
+
+// Commit 提交 记录 COMMIT
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	tx.record("COMMIT", nil)
+	return nil
+}
+
+// Rollback 回滚 记录 ROLLBACK
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	tx.record("ROLLBACK", nil)
+	return nil
+}
+
+// ExecContext 执行 保存点语句不需要 Stub
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if tx.done {
+		return nil, sql.ErrTxDone
+	}
+	upper := strings.ToUpper(query)
+	if strings.HasPrefix(upper, "SAVEPOINT ") ||
+		strings.HasPrefix(upper, "RELEASE SAVEPOINT ") ||
+		strings.HasPrefix(upper, "ROLLBACK TO SAVEPOINT ") {
+		tx.record(query, args)
+		return result{}, nil
+	}
+	return tx.DB.ExecContext(ctx, query, args...)
+}
+
+// Exec 执行
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+// record 记录执行
+func (db *DB) record(query string, args []interface{}) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.calls = append(db.calls, Call{
+		Query: query,
+		Args:  args,
+	})
+}
+
+// match 记录执行并查找 Stub
+func (db *DB) match(query string, args []interface{}) (*Stub, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.calls = append(db.calls, Call{
+		Query: query,
+		Args:  args,
+	})
+	for _, s := range db.stubs {
+		if s.times > 0 && s.used >= s.times {
+			continue
+		}
+		if !s.re.MatchString(query) {
+			continue
+		}
+		s.used++
+		if s.err != nil {
+			return nil, s.err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("dbtest: unexpected query: %s", mcommon.DbInterpolateSQL(query, args))
+}
+
+// assign 把行写入dest dest为指针
+func assign(dest reflect.Value, row interface{}) error {
+	if dest.Kind() != reflect.Ptr || dest.IsNil() {
+		return fmt.Errorf("dbtest: dest type error: %s", dest.Type())
+	}
+	target := dest.Elem()
+	for target.Kind() == reflect.Ptr {
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		target = target.Elem()
+	}
+	rv := reflect.ValueOf(row)
+	if rv.IsValid() && rv.Type().AssignableTo(target.Type()) {
+		target.Set(rv)
+		return nil
+	}
+	if rv.Kind() == reflect.Ptr && rv.Elem().Type().AssignableTo(target.Type()) {
+		target.Set(rv.Elem())
+		return nil
+	}
+	m, ok := row.(mcommon.H)
+	if !ok {
+		m, ok = row.(map[string]interface{})
+	}
+	if !ok || target.Kind() != reflect.Struct {
+		return fmt.Errorf("dbtest: row type error: %T to %s", row, target.Type())
+	}
+	return assignMap(target, m)
+}
+
+// assignMap 按 db tag 把map写入结构体
+func assignMap(target reflect.Value, m map[string]interface{}) error {
+	t := target.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("db"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			err := assignMap(target.Field(i), m)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		v, ok := m[name]
+		if !ok || v == nil {
+			continue
+		}
+		fv := reflect.ValueOf(v)
+		switch {
+		case fv.Type().AssignableTo(field.Type):
+			target.Field(i).Set(fv)
+		case fv.Type().ConvertibleTo(field.Type):
+			target.Field(i).Set(fv.Convert(field.Type))
+		default:
+			scanner, ok := target.Field(i).Addr().Interface().(sql.Scanner)
+			if !ok {
+				return fmt.Errorf("dbtest: column %s type error: %T to %s", name, v, field.Type)
+			}
+			err := scanner.Scan(v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// result 执行结果
+type result struct {
+	lastID       int64
+	rowsAffected int64
+}
+
+// LastInsertId 插入id
+func (r result) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+// RowsAffected 影响行数
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
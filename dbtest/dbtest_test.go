@@ -0,0 +1,47 @@
+package dbtest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moremorefun/mcommon"
+	"github.com/moremorefun/mcommon/dbtest"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestQueryxContext(t *testing.T) {
+	db := dbtest.New()
+	db.Stub(`^SELECT id, name FROM t_user`).WillReturnRows(
+		user{ID: 1, Name: "a"},
+		mcommon.H{"id": 2, "name": "b"},
+	)
+
+	var users []*user
+	err := mcommon.DbEachNamedContent(
+		context.Background(),
+		db,
+		"SELECT id, name FROM t_user WHERE id IN (:ids)",
+		mcommon.H{"ids": []int64{1, 2}},
+		func() interface{} {
+			return &user{}
+		},
+		func(row interface{}) error {
+			users = append(users, row.(*user))
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Name != "a" || users[1].ID != 2 {
+		t.Fatalf("users: %v", users)
+	}
+	calls := db.Calls()
+	if len(calls) != 1 || calls[0].Query != "SELECT id, name FROM t_user WHERE id IN (?, ?)" {
+		t.Fatalf("calls: %v", calls)
+	}
+}
@@ -0,0 +1,179 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/moremorefun/mcommon"
+)
+
+// rowsContextKey context中预设行的key
+type rowsContextKey struct{}
+
+// QueryxContext 逐行查询 结构体行按 db tag 转为列 map行按key排序转为列
+func (db *DB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	s, err := db.match(query, args)
+	if err != nil {
+		return nil, err
+	}
+	columns, values, err := rowValues(s.rows)
+	if err != nil {
+		return nil, err
+	}
+	db.sqlOnce.Do(func() {
+		db.sqlDB = sqlx.NewDb(sql.OpenDB(connector{}), "mysql")
+	})
+	ctx = context.WithValue(ctx, rowsContextKey{}, &rows{
+		columns: columns,
+		values:  values,
+	})
+	return db.sqlDB.QueryxContext(ctx, query)
+}
+
+// rowValues 把预设行转为列名和列值
+func rowValues(rows []interface{}) ([]string, [][]driver.Value, error) {
+	var columns []string
+	var values [][]driver.Value
+	for _, row := range rows {
+		m := rowMap(row)
+		if columns == nil {
+			for k := range m {
+				columns = append(columns, k)
+			}
+			sort.Strings(columns)
+		}
+		vs := make([]driver.Value, len(columns))
+		for i, column := range columns {
+			var err error
+			vs[i], err = driver.DefaultParameterConverter.ConvertValue(m[column])
+			if err != nil {
+				return nil, nil, fmt.Errorf("dbtest: column %s error: %w", column, err)
+			}
+		}
+		values = append(values, vs)
+	}
+	return columns, values, nil
+}
+
+// rowMap 把预设行转为map 结构体按 db tag 其他类型为单列 value
+func rowMap(row interface{}) map[string]interface{} {
+	switch m := row.(type) {
+	case mcommon.H:
+		return m
+	case map[string]interface{}:
+		return m
+	}
+	rv := reflect.ValueOf(row)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct {
+		rv = rv.Elem()
+	}
+	_, isValuer := row.(driver.Valuer)
+	if rv.Kind() != reflect.Struct || isValuer || driver.IsValue(row) {
+		return map[string]interface{}{"value": row}
+	}
+	m := map[string]interface{}{}
+	structMap(rv, m)
+	return m
+}
+
+// structMap 按 db tag 把结构体写入map 与 assignMap 规则一致
+func structMap(rv reflect.Value, m map[string]interface{}) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("db"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			structMap(rv.Field(i), m)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		m[name] = rv.Field(i).Interface()
+	}
+}
+
+// connector 只用于返回预设行的驱动
+type connector struct{}
+
+// Connect 连接
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return conn{}, nil
+}
+
+// Driver 驱动
+func (c connector) Driver() driver.Driver {
+	return c
+}
+
+// Open 连接
+func (c connector) Open(name string) (driver.Conn, error) {
+	return conn{}, nil
+}
+
+// conn 从context中读取预设行
+type conn struct{}
+
+// Prepare 不支持
+func (c conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("dbtest: prepare not supported")
+}
+
+// Close 关闭
+func (c conn) Close() error {
+	return nil
+}
+
+// Begin 不支持
+func (c conn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("dbtest: begin not supported")
+}
+
+// QueryContext 返回context中的预设行
+func (c conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r, ok := ctx.Value(rowsContextKey{}).(*rows)
+	if !ok {
+		return nil, fmt.Errorf("dbtest: rows miss")
+	}
+	return r, nil
+}
+
+// rows 预设行
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+// Columns 列名
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+// Close 关闭
+func (r *rows) Close() error {
+	return nil
+}
+
+// Next 下一行
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -5,9 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"reflect"
-	"runtime"
+	"strconv"
 	"strings"
-	"time"
+	"sync/atomic"
 
 	// 导入mysql
 	_ "github.com/go-sql-driver/mysql"
@@ -25,38 +25,20 @@ type DbExeAble interface {
 	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 }
 
-// isShowSQL 是否显示执行的sql语句
-var isShowSQL bool
+// DbRaw 原样写入sql的表达式 如 DbRaw("count + VALUES(count)")
+type DbRaw string
 
-// debugSQLMap 记录
-var debugSQLMap map[string]string
-
-// debugSQLCountMap sql次数
-var debugSQLCountMap map[string]int64
-
-// DbCreate 创建数据库链接
+// DbCreate 创建数据库链接 失败时退出进程 需要返回错误时使用 DbOpen
 func DbCreate(dataSourceName string, showSQL bool) *sqlx.DB {
-	isShowSQL = showSQL
-	debugSQLMap = make(map[string]string)
-	debugSQLCountMap = make(map[string]int64)
-
-	var err error
-	var db *sqlx.DB
-
-	db, err = sqlx.Connect("mysql", dataSourceName)
-	if err != nil {
-		Log.Fatalf("db connect error: %s", err.Error())
-		return nil
-	}
-
-	count := runtime.NumCPU()*20 + 1
-	db.SetMaxOpenConns(count)
-	db.SetMaxIdleConns(count)
-	db.SetConnMaxLifetime(1 * time.Hour)
-
-	err = db.Ping()
+	db, err := DbOpen(
+		context.Background(),
+		DbOptions{
+			DSN:     dataSourceName,
+			ShowSQL: showSQL,
+		},
+	)
 	if err != nil {
-		Log.Fatalf("db ping error: %s", err.Error())
+		Log.Fatalf("db create error: %s", err.Error())
 		return nil
 	}
 	return db
@@ -64,34 +46,7 @@ func DbCreate(dataSourceName string, showSQL bool) *sqlx.DB {
 
 // DbExecuteCountManyContent 返回sql语句并返回执行行数
 func DbExecuteCountManyContent(ctx context.Context, tx DbExeAble, query string, n int, args ...interface{}) (int64, error) {
-	var err error
-	insertArgs := strings.Repeat("(?),", n)
-	insertArgs = strings.TrimSuffix(insertArgs, ",")
-	query = fmt.Sprintf(query, insertArgs)
-	query, args, err = sqlx.In(query, args...)
-	if err != nil {
-		return 0, err
-	}
-	query = tx.Rebind(query)
-	if isShowSQL {
-		queryStr := query + ";"
-		for _, arg := range args {
-			_, ok := arg.(string)
-			if ok {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`"%s"`, arg), 1)
-			} else {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`%v`, arg), 1)
-			}
-		}
-		Log.Debugf(queryStr)
-		debugSQLMap[query] = queryStr
-		debugSQLCountMap[query] += 1
-	}
-	ret, err := tx.ExecContext(
-		ctx,
-		query,
-		args...,
-	)
+	ret, err := dbExecuteManyContent(ctx, tx, query, n, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -102,36 +57,26 @@ func DbExecuteCountManyContent(ctx context.Context, tx DbExeAble, query string,
 	return count, nil
 }
 
-// DbExecuteLastIDNamedContent 执行sql语句并返回lastID
-func DbExecuteLastIDNamedContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (int64, error) {
-	query, args, err := sqlx.Named(query, argMap)
+// dbExecuteManyContent 执行多行sql语句
+func dbExecuteManyContent(ctx context.Context, tx DbExeAble, query string, n int, args ...interface{}) (sql.Result, error) {
+	tx, query, err := dbShardQuery(ctx, tx, query)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	insertArgs := strings.Repeat("(?),", n)
+	insertArgs = strings.TrimSuffix(insertArgs, ",")
+	query = fmt.Sprintf(query, insertArgs)
 	query, args, err = sqlx.In(query, args...)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	query = tx.Rebind(query)
-	if isShowSQL {
-		queryStr := query + ";"
-		for _, arg := range args {
-			_, ok := arg.(string)
-			if ok {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`"%s"`, arg), 1)
-			} else {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`%v`, arg), 1)
-			}
-		}
-		Log.Debugf(queryStr)
-		debugSQLMap[query] = queryStr
-		debugSQLCountMap[query] += 1
-	}
-	ret, err := tx.ExecContext(
-		ctx,
-		query,
-		args...,
-	)
+	return dbExec(ctx, tx, query, args)
+}
+
+// DbExecuteLastIDNamedContent 执行sql语句并返回lastID
+func DbExecuteLastIDNamedContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (int64, error) {
+	ret, err := dbExecuteNamedContent(ctx, tx, query, argMap)
 	if err != nil {
 		return 0, err
 	}
@@ -144,75 +89,52 @@ func DbExecuteLastIDNamedContent(ctx context.Context, tx DbExeAble, query string
 
 // DbExecuteCountNamedContent 执行sql语句返回执行个数
 func DbExecuteCountNamedContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (int64, error) {
-	query, args, err := sqlx.Named(query, argMap)
+	ret, err := dbExecuteNamedContent(ctx, tx, query, argMap)
 	if err != nil {
 		return 0, err
 	}
-	query, args, err = sqlx.In(query, args...)
+	count, err := ret.RowsAffected()
 	if err != nil {
 		return 0, err
 	}
-	query = tx.Rebind(query)
-	if isShowSQL {
-		queryStr := query + ";"
-		for _, arg := range args {
-			_, ok := arg.(string)
-			if ok {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`"%s"`, arg), 1)
-			} else {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`%v`, arg), 1)
-			}
-		}
-		Log.Debugf(queryStr)
-		debugSQLMap[query] = queryStr
-		debugSQLCountMap[query] += 1
-	}
-	ret, err := tx.ExecContext(
-		ctx,
-		query,
-		args...,
-	)
+	return count, nil
+}
+
+// dbExecuteNamedContent 执行sql语句
+func dbExecuteNamedContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (sql.Result, error) {
+	tx, query, err := dbShardQuery(ctx, tx, query)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	count, err := ret.RowsAffected()
+	query, args, err := dbNamedIn(tx, query, argMap)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return count, nil
+	return dbExec(ctx, tx, query, args)
 }
 
 // DbGetNamedContent 执行sql查询并返回当个元素
 func DbGetNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}) (bool, error) {
-	query, args, err := sqlx.Named(query, argMap)
+	tx, query, err := dbShardQuery(ctx, tx, query)
 	if err != nil {
 		return false, err
 	}
-	query, args, err = sqlx.In(query, args...)
+	query, args, err := dbNamedIn(tx, query, argMap)
 	if err != nil {
 		return false, err
 	}
-	query = tx.Rebind(query)
-	if isShowSQL {
-		queryStr := query + ";"
-		for _, arg := range args {
-			_, ok := arg.(string)
-			if ok {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`"%s"`, arg), 1)
-			} else {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`%v`, arg), 1)
-			}
+	err = dbQuery(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		err := tx.GetContext(
+			ctx,
+			dest,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
 		}
-		Log.Debugf(queryStr)
-		debugSQLMap[query] = queryStr
-		debugSQLCountMap[query] += 1
-	}
-	err = tx.GetContext(
-		ctx,
-		dest,
-		query,
-		args...,
-	)
+		return 1, nil
+	})
 	if err == sql.ErrNoRows {
 		// 没有元素
 		return false, nil
@@ -226,35 +148,26 @@ func DbGetNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, quer
 
 // DbSelectNamedContent 执行sql查询并返回多行
 func DbSelectNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}) error {
-	query, args, err := sqlx.Named(query, argMap)
+	tx, query, err := dbShardQuery(ctx, tx, query)
 	if err != nil {
 		return err
 	}
-	query, args, err = sqlx.In(query, args...)
+	query, args, err := dbNamedIn(tx, query, argMap)
 	if err != nil {
 		return err
 	}
-	query = tx.Rebind(query)
-	if isShowSQL {
-		queryStr := query + ";"
-		for _, arg := range args {
-			_, ok := arg.(string)
-			if ok {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`"%s"`, arg), 1)
-			} else {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`%v`, arg), 1)
-			}
+	err = dbQuery(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		err := tx.SelectContext(
+			ctx,
+			dest,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
 		}
-		Log.Debugf(queryStr)
-		debugSQLMap[query] = queryStr
-		debugSQLCountMap[query] += 1
-	}
-	err = tx.SelectContext(
-		ctx,
-		dest,
-		query,
-		args...,
-	)
+		return int64(reflect.Indirect(reflect.ValueOf(dest)).Len()), nil
+	})
 	if err == sql.ErrNoRows {
 		// 没有元素
 		return nil
@@ -266,8 +179,56 @@ func DbSelectNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, q
 	return nil
 }
 
+// dbNamedIn 转换命名参数和IN参数
+func dbNamedIn(tx DbExeAble, query string, argMap map[string]interface{}) (string, []interface{}, error) {
+	query, args, err := sqlx.Named(query, argMap)
+	if err != nil {
+		return "", nil, err
+	}
+	query, args, err = sqlx.In(query, args...)
+	if err != nil {
+		return "", nil, err
+	}
+	return tx.Rebind(query), args, nil
+}
+
+// dbExec 执行sql语句
+func dbExec(ctx context.Context, tx DbExeAble, query string, args []interface{}) (sql.Result, error) {
+	var ret sql.Result
+	err := dbQuery(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		var err error
+		ret, err = tx.ExecContext(
+			ctx,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
+		}
+		count, _ := ret.RowsAffected()
+		return count, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 // DbUpdateKV 更新
+// values 可以为 DbOp 条件 updateMap 的值可以为 DbRaw 或 DbExpr 表达式
+// 表设置了 DbTablePolicy 时自动更新版本并过滤已删除的行
 func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, keys []string, values []interface{}) (int64, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+	return dbAuditWrite(ctx, tx, table, DbAuditActionUpdate, keys, values, false, func(ctx context.Context, tx DbExeAble) (int64, error) {
+		return dbUpdateKV(ctx, tx, table, updateMap, keys, values)
+	})
+}
+
+// dbUpdateKV 更新
+func dbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, keys []string, values []interface{}) (int64, error) {
 	keysLen := len(keys)
 	if 0 == keysLen {
 		return 0, fmt.Errorf("keys len error")
@@ -275,49 +236,39 @@ func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, ke
 	if keysLen != len(values) {
 		return 0, fmt.Errorf("value len error")
 	}
-	updateLastIndex := len(updateMap) - 1
+	policy := DbGetTablePolicy(table)
+	isCheckVersion := false
+	if policy.VersionColumn != "" {
+		_, ok := updateMap[policy.VersionColumn]
+		if ok {
+			return 0, fmt.Errorf("version column can not update: %s", policy.VersionColumn)
+		}
+		versionUpdateMap := H{}
+		for k, v := range updateMap {
+			versionUpdateMap[k] = v
+		}
+		versionUpdateMap[policy.VersionColumn] = DbRaw(policy.VersionColumn + "+1")
+		updateMap = versionUpdateMap
+		isCheckVersion = IsStringInSlice(keys, policy.VersionColumn)
+	}
+	keys, values = dbPolicyWhere(policy, keys, values)
 
 	argMap := H{}
 	query := strings.Builder{}
 	query.WriteString("UPDATE\n")
 	query.WriteString(table)
 	query.WriteString("\nSET\n")
-	var updateIndex int
-	for k, v := range updateMap {
-		query.WriteString(k)
-		query.WriteString("=:")
-		query.WriteString(k)
-		if updateIndex == updateLastIndex {
-			query.WriteString("\n")
-		} else {
-			query.WriteString(",\n")
-		}
-		updateIndex++
-		argMap[k] = v
+	err := dbWriteKVSet(&query, argMap, updateMap)
+	if err != nil {
+		return 0, err
 	}
 	query.WriteString("WHERE\n")
-	for i, key := range keys {
-		if i != 0 {
-			query.WriteString("AND ")
-		}
-		value := values[i]
-		query.WriteString(key)
-		rt := reflect.TypeOf(value)
-		switch rt.Kind() {
-		case reflect.Slice:
-			s := reflect.ValueOf(value)
-			if s.Len() == 0 {
-				return 0, nil
-			}
-			query.WriteString(" IN (:")
-			query.WriteString(key)
-			query.WriteString(")")
-		default:
-			query.WriteString("=:")
-			query.WriteString(key)
-		}
-		query.WriteString("\n")
-		argMap[key] = value
+	ok, err := dbWriteKVWhere(&query, argMap, keys, values)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
 	}
 
 	count, err := DbExecuteCountNamedContent(
@@ -329,11 +280,56 @@ func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, ke
 	if err != nil {
 		return 0, err
 	}
+	if isCheckVersion && count == 0 {
+		return 0, ErrStaleVersion
+	}
+	if count > 0 {
+		dbCacheInvalidateTable(ctx, tx, table)
+	}
 	return count, nil
 }
 
 // DbDeleteKV 删除
+// values 可以为 DbOp 条件 表设置了软删除时改为设置删除时间
 func DbDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string, values []interface{}) (int64, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+	policy := DbGetTablePolicy(table)
+	if policy.DeletedColumn != "" {
+		if 0 == len(keys) {
+			return 0, fmt.Errorf("keys len error")
+		}
+		return dbAuditWrite(ctx, tx, table, DbAuditActionDelete, keys, values, false, func(ctx context.Context, tx DbExeAble) (int64, error) {
+			return dbUpdateKV(
+				ctx,
+				tx,
+				table,
+				H{
+					policy.DeletedColumn: DbRaw("NOW()"),
+				},
+				keys,
+				values,
+			)
+		})
+	}
+	return DbHardDeleteKV(ctx, tx, table, keys, values)
+}
+
+// DbHardDeleteKV 删除 不使用软删除
+func DbHardDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string, values []interface{}) (int64, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+	return dbAuditWrite(ctx, tx, table, DbAuditActionDelete, keys, values, true, func(ctx context.Context, tx DbExeAble) (int64, error) {
+		return dbHardDeleteKV(ctx, tx, table, keys, values)
+	})
+}
+
+// dbHardDeleteKV 删除
+func dbHardDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string, values []interface{}) (int64, error) {
 	keysLen := len(keys)
 	if 0 == keysLen {
 		return 0, fmt.Errorf("keys len error")
@@ -347,28 +343,12 @@ func DbDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string,
 	query.WriteString("DELETE\nFROM\n")
 	query.WriteString(table)
 	query.WriteString("\nWHERE\n")
-	for i, key := range keys {
-		if i != 0 {
-			query.WriteString("AND ")
-		}
-		value := values[i]
-		query.WriteString(key)
-		rt := reflect.TypeOf(value)
-		switch rt.Kind() {
-		case reflect.Slice:
-			s := reflect.ValueOf(value)
-			if s.Len() == 0 {
-				return 0, nil
-			}
-			query.WriteString(" IN (:")
-			query.WriteString(key)
-			query.WriteString(")")
-		default:
-			query.WriteString("=:")
-			query.WriteString(key)
-		}
-		query.WriteString("\n")
-		argMap[key] = value
+	ok, err := dbWriteKVWhere(&query, argMap, keys, values)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
 	}
 
 	count, err := DbExecuteCountNamedContent(
@@ -380,22 +360,234 @@ func DbDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string,
 	if err != nil {
 		return 0, err
 	}
+	if count > 0 {
+		dbCacheInvalidateTable(ctx, tx, table)
+	}
 	return count, nil
 }
 
+// DbSelectOpt 查询选项
+type DbSelectOpt struct {
+	// Columns 查询的列 为空时为 *
+	Columns []string
+	// OrderBy 排序 如 "id DESC"
+	OrderBy []string
+	// Limit 为0时不限制
+	Limit int64
+	// Offset 偏移
+	Offset int64
+	// Lock 锁 DbLockForUpdate DbLockShareMode 或 DbLockSkipLocked
+	Lock string
+	// WithDeleted 是否包含软删除的行
+	WithDeleted bool
+}
+
+const (
+	// DbLockForUpdate 排他锁
+	DbLockForUpdate = "FOR UPDATE"
+	// DbLockShareMode 共享锁
+	DbLockShareMode = "LOCK IN SHARE MODE"
+	// DbLockSkipLocked 排他锁 跳过已被锁定的行 需要mysql8.0
+	DbLockSkipLocked = "FOR UPDATE SKIP LOCKED"
+)
+
+// DbSelectKV 按条件查询多行 values 可以为 DbOp 条件
+func DbSelectKV(ctx context.Context, tx DbExeAble, dest interface{}, table string, keys []string, values []interface{}, opt *DbSelectOpt) error {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	query, argMap, ok, err := dbBuildSelectKV(table, keys, values, opt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	return DbSelectNamedContent(
+		ctx,
+		tx,
+		dest,
+		query,
+		argMap,
+	)
+}
+
+// DbGetKV 按条件查询单行
+func DbGetKV(ctx context.Context, tx DbExeAble, dest interface{}, table string, keys []string, values []interface{}, opt *DbSelectOpt) (bool, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return false, err
+	}
+	getOpt := DbSelectOpt{}
+	if opt != nil {
+		getOpt = *opt
+	}
+	getOpt.Limit = 1
+	query, argMap, ok, err := dbBuildSelectKV(table, keys, values, &getOpt)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+	return DbGetNamedContent(
+		ctx,
+		tx,
+		dest,
+		query,
+		argMap,
+	)
+}
+
+// dbBuildSelectKV 生成查询语句 ok为false时表示条件为空集合无需查询
+func dbBuildSelectKV(table string, keys []string, values []interface{}, opt *DbSelectOpt) (string, H, bool, error) {
+	if len(keys) != len(values) {
+		return "", nil, false, fmt.Errorf("value len error")
+	}
+	if opt == nil {
+		opt = &DbSelectOpt{}
+	}
+	switch opt.Lock {
+	case "", DbLockForUpdate, DbLockShareMode, DbLockSkipLocked:
+	default:
+		return "", nil, false, fmt.Errorf("lock error: %s", opt.Lock)
+	}
+	if !opt.WithDeleted {
+		keys, values = dbPolicyWhere(DbGetTablePolicy(table), keys, values)
+	}
+	argMap := H{}
+	query := strings.Builder{}
+	query.WriteString("SELECT\n")
+	if len(opt.Columns) == 0 {
+		query.WriteString("*")
+	} else {
+		query.WriteString(strings.Join(opt.Columns, ",\n"))
+	}
+	query.WriteString("\nFROM\n")
+	query.WriteString(table)
+	query.WriteString("\n")
+	if len(keys) > 0 {
+		query.WriteString("WHERE\n")
+		ok, err := dbWriteKVWhere(&query, argMap, keys, values)
+		if err != nil {
+			return "", nil, false, err
+		}
+		if !ok {
+			return "", nil, false, nil
+		}
+	}
+	if len(opt.OrderBy) > 0 {
+		query.WriteString("ORDER BY\n")
+		query.WriteString(strings.Join(opt.OrderBy, ",\n"))
+		query.WriteString("\n")
+	}
+	if opt.Limit > 0 {
+		query.WriteString("LIMIT ")
+		query.WriteString(strconv.FormatInt(opt.Limit, 10))
+		query.WriteString("\n")
+		if opt.Offset > 0 {
+			query.WriteString("OFFSET ")
+			query.WriteString(strconv.FormatInt(opt.Offset, 10))
+			query.WriteString("\n")
+		}
+	}
+	if opt.Lock != "" {
+		query.WriteString(opt.Lock)
+		query.WriteString("\n")
+	}
+	return query.String(), argMap, true, nil
+}
+
+// DbTxAble 事物接口 *sqlx.Tx 实现了该接口
+type DbTxAble interface {
+	DbExeAble
+	Commit() error
+	Rollback() error
+}
+
+// DbBeginAble 可开启事物的自定义数据库对象
+type DbBeginAble interface {
+	DbExeAble
+	DbBeginTx(ctx context.Context, opts *sql.TxOptions) (DbTxAble, error)
+}
+
+// dbTxContextKey context中事物的key
+type dbTxContextKey struct{}
+
+// dbSavepointID 保存点序号
+var dbSavepointID int64
+
+// DbContextWithTx 把事物放入context
+func DbContextWithTx(ctx context.Context, tx DbExeAble) context.Context {
+	return context.WithValue(ctx, dbTxContextKey{}, tx)
+}
+
+// DbTxFromContext 获取context中的事物
+func DbTxFromContext(ctx context.Context) (DbExeAble, bool) {
+	tx, ok := ctx.Value(dbTxContextKey{}).(DbExeAble)
+	return tx, ok
+}
+
+// DbFromContext context中有db开启的事物时返回事物 否则返回db
+// 其他数据库的事物不会替换db
+func DbFromContext(ctx context.Context, db DbExeAble) DbExeAble {
+	tx, ok := DbTxFromContext(ctx)
+	if !ok || !dbHookKeyAble(db) {
+		return db
+	}
+	if tx == db {
+		return tx
+	}
+	parent, ok := dbTxParent(tx)
+	if ok && parent == db {
+		return tx
+	}
+	return db
+}
+
 // DbTransaction 执行事物
-func DbTransaction(ctx context.Context, db *sqlx.DB, f func(dbTx DbExeAble) error) error {
-	isComment := false
-	tx, err := db.BeginTxx(ctx, nil)
+// db为事物或context中有db开启的事物时使用SAVEPOINT嵌套执行
+func DbTransaction(ctx context.Context, db DbExeAble, f func(dbTx DbExeAble) error) error {
+	return DbTransactionContext(ctx, db, func(ctx context.Context, dbTx DbExeAble) error {
+		return f(dbTx)
+	})
+}
+
+// DbTransactionContext 执行事物 传入f的context中带有事物
+func DbTransactionContext(ctx context.Context, db DbExeAble, f func(ctx context.Context, dbTx DbExeAble) error) error {
+	return dbTransactionOpts(ctx, db, nil, f)
+}
+
+// dbTransactionOpts 执行事物
+func dbTransactionOpts(ctx context.Context, db DbExeAble, opts *sql.TxOptions, f func(ctx context.Context, dbTx DbExeAble) error) error {
+	db = DbFromContext(ctx, db)
+	var tx DbTxAble
+	var err error
+	switch v := db.(type) {
+	case DbTxAble:
+		return dbSavepoint(ctx, v, f)
+	case *sqlx.DB:
+		tx, err = v.BeginTxx(ctx, opts)
+	case DbBeginAble:
+		tx, err = v.DbBeginTx(ctx, opts)
+	default:
+		return fmt.Errorf("db type error: %T", db)
+	}
 	if err != nil {
 		return err
 	}
+	unlink := dbLinkTx(tx, db)
+	defer unlink()
+	cacheEnd := dbCacheTxBegin(tx)
+	isComment := false
 	defer func() {
 		if !isComment {
 			_ = tx.Rollback()
 		}
+		cacheEnd(ctx, isComment)
 	}()
-	err = f(tx)
+	err = f(DbContextWithTx(ctx, tx), tx)
 	if err != nil {
 		return err
 	}
@@ -407,12 +599,37 @@ func DbTransaction(ctx context.Context, db *sqlx.DB, f func(dbTx DbExeAble) erro
 	return nil
 }
 
+// dbSavepoint 在已有事物中使用保存点执行
+func dbSavepoint(ctx context.Context, tx DbTxAble, f func(ctx context.Context, dbTx DbExeAble) error) error {
+	name := fmt.Sprintf("mcommon_sp_%d", atomic.AddInt64(&dbSavepointID, 1))
+	_, err := tx.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return err
+	}
+	isRelease := false
+	defer func() {
+		if !isRelease {
+			_, _ = tx.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+name)
+		}
+	}()
+	err = f(DbContextWithTx(ctx, tx), tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	if err != nil {
+		return err
+	}
+	isRelease = true
+	return nil
+}
+
 // DbGetDebugMap 获取debug sql 记录
 func DbGetDebugMap() map[string]string {
-	return debugSQLMap
+	return dbDebugHook.getSQLMap()
 }
 
 // DbGetDebugCountMap 获取debug sql 次数
 func DbGetDebugCountMap() map[string]int64 {
-	return debugSQLCountMap
+	return dbDebugHook.getCountMap()
 }
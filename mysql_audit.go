@@ -0,0 +1,214 @@
+package mcommon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// DbAuditTable 审计日志表名 表结构见 sql/audit_log.sql
+const DbAuditTable = "t_audit_log"
+
+const (
+	// DbAuditActionUpdate 更新
+	DbAuditActionUpdate = "update"
+	// DbAuditActionDelete 删除 包括软删除
+	DbAuditActionDelete = "delete"
+)
+
+// dbAuditActorContextKey context中操作者的key
+type dbAuditActorContextKey struct{}
+
+// dbAuditRequestIDContextKey context中请求id的key
+type dbAuditRequestIDContextKey struct{}
+
+// DbAuditLog 审计日志
+type DbAuditLog struct {
+	ID         int64  `db:"id" json:"id"`
+	TableName  string `db:"table_name" json:"table_name"`
+	Pk         string `db:"pk" json:"pk"`
+	Action     string `db:"action" json:"action"`
+	Actor      string `db:"actor" json:"actor"`
+	RequestID  string `db:"request_id" json:"request_id"`
+	BeforeData string `db:"before_data" json:"before_data"`
+	AfterData  string `db:"after_data" json:"after_data"`
+	CreatedAt  int64  `db:"created_at" json:"created_at"`
+}
+
+// DbContextWithActor 设置审计日志的操作者
+// 未设置时使用 gin.Context 中的 user_id
+func DbContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, dbAuditActorContextKey{}, actor)
+}
+
+// DbContextWithRequestID 设置审计日志的请求id
+// 未设置时使用 gin.Context 中的 request_id
+func DbContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, dbAuditRequestIDContextKey{}, requestID)
+}
+
+// DbAuditHistory 获取一行的修改记录 按时间顺序
+func DbAuditHistory(ctx context.Context, tx DbExeAble, table string, pk interface{}) ([]*DbAuditLog, error) {
+	var rows []*DbAuditLog
+	err := DbSelectKV(
+		ctx,
+		tx,
+		&rows,
+		DbAuditTable,
+		[]string{"table_name", "pk"},
+		[]interface{}{table, dbAuditString(pk)},
+		&DbSelectOpt{
+			OrderBy: []string{"id ASC"},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// dbAuditWrite 表开启审计时 在同一个事物中查询修改前后的行并写入审计日志
+// isHardDelete 为true时修改前的行包含软删除的行 修改后的行为null
+func dbAuditWrite(ctx context.Context, tx DbExeAble, table string, action string, keys []string, values []interface{}, isHardDelete bool, do func(ctx context.Context, tx DbExeAble) (int64, error)) (int64, error) {
+	policy := DbGetTablePolicy(table)
+	if !policy.Audit {
+		return do(ctx, tx)
+	}
+	if len(keys) != len(values) {
+		return 0, fmt.Errorf("value len error")
+	}
+	pk := policy.PrimaryKey
+	if pk == "" {
+		pk = "id"
+	}
+	var count int64
+	err := DbTransactionContext(ctx, tx, func(ctx context.Context, dbTx DbExeAble) error {
+		befores, err := dbAuditSelect(ctx, dbTx, table, keys, values, &DbSelectOpt{
+			Lock:        DbLockForUpdate,
+			WithDeleted: isHardDelete,
+		})
+		if err != nil {
+			return err
+		}
+		count, err = do(ctx, dbTx)
+		if err != nil {
+			return err
+		}
+		if len(befores) == 0 || count == 0 {
+			return nil
+		}
+		var pkValues []interface{}
+		for _, before := range befores {
+			v, ok := before[pk]
+			if !ok {
+				return fmt.Errorf("audit row miss column: %s", pk)
+			}
+			pkValues = append(pkValues, v)
+		}
+		afterMap := map[string]map[string]interface{}{}
+		if !isHardDelete {
+			afters, err := dbAuditSelect(ctx, dbTx, table, []string{pk}, []interface{}{pkValues}, &DbSelectOpt{
+				WithDeleted: true,
+			})
+			if err != nil {
+				return err
+			}
+			for _, after := range afters {
+				afterMap[dbAuditString(after[pk])] = after
+			}
+		}
+		actor, requestID := dbAuditActor(ctx)
+		now := TimeGetMillisecond()
+		var logs []H
+		for _, before := range befores {
+			pkValue := dbAuditString(before[pk])
+			after := afterMap[pkValue]
+			beforeData, err := json.Marshal(before)
+			if err != nil {
+				return err
+			}
+			afterData, err := json.Marshal(after)
+			if err != nil {
+				return err
+			}
+			logs = append(logs, H{
+				"table_name":  table,
+				"pk":          pkValue,
+				"action":      action,
+				"actor":       actor,
+				"request_id":  requestID,
+				"before_data": string(beforeData),
+				"after_data":  string(afterData),
+				"created_at":  now,
+			})
+		}
+		_, _, err = DbInsertMany(ctx, dbTx, DbAuditTable, logs, nil)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// dbAuditSelect 查询行 []byte 转为字符串便于生成json
+func dbAuditSelect(ctx context.Context, tx DbExeAble, table string, keys []string, values []interface{}, opt *DbSelectOpt) ([]map[string]interface{}, error) {
+	query, argMap, ok, err := dbBuildSelectKV(table, keys, values, opt)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	var rows []map[string]interface{}
+	err = DbEachNamedContent(
+		ctx,
+		tx,
+		query,
+		argMap,
+		func() interface{} {
+			return &map[string]interface{}{}
+		},
+		func(row interface{}) error {
+			m := *row.(*map[string]interface{})
+			for k, v := range m {
+				if bs, ok := v.([]byte); ok {
+					m[k] = string(bs)
+				}
+			}
+			rows = append(rows, m)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// dbAuditActor 获取操作者和请求id
+func dbAuditActor(ctx context.Context) (string, string) {
+	actor, ok := ctx.Value(dbAuditActorContextKey{}).(string)
+	if !ok {
+		// gin.Context 的 Value 读取 c.Set 的值
+		actor = dbAuditString(ctx.Value("user_id"))
+	}
+	requestID, ok := ctx.Value(dbAuditRequestIDContextKey{}).(string)
+	if !ok {
+		requestID = dbAuditString(ctx.Value("request_id"))
+	}
+	return actor, requestID
+}
+
+// dbAuditString 转换为字符串 nil 为空字符串
+func dbAuditString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,48 @@
+package mcommon_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/moremorefun/mcommon"
+	"github.com/moremorefun/mcommon/dbtest"
+)
+
+func TestDbHardDeleteKVAudit(t *testing.T) {
+	mcommon.DbSetTablePolicy("t_audit_user", mcommon.DbTablePolicy{
+		DeletedColumn: "deleted_at",
+		Audit:         true,
+	})
+	defer mcommon.DbSetTablePolicy("t_audit_user", mcommon.DbTablePolicy{})
+
+	db := dbtest.New()
+	db.Stub(`^SELECT`).WillReturnRows(
+		mcommon.H{"id": 1, "name": []byte("a"), "deleted_at": nil},
+		mcommon.H{"id": 2, "name": []byte("b"), "deleted_at": "2020-01-01 00:00:00"},
+	)
+	db.Stub(`^DELETE`).WillReturnResult(0, 2)
+	db.Stub(`^INSERT INTO t_audit_log`).WillReturnResult(1, 2)
+
+	ctx := mcommon.DbContextWithActor(context.Background(), "admin")
+	count, err := mcommon.DbHardDeleteKV(ctx, db, "t_audit_user", []string{"id"}, []interface{}{[]int64{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("count: %d", count)
+	}
+
+	calls := db.Calls()
+	if len(calls) != 5 {
+		t.Fatalf("calls: %v", calls)
+	}
+	// 硬删除会删除软删除的行 修改前的行需要包含它们
+	if strings.Contains(calls[1].Query, "deleted_at") || !strings.Contains(calls[1].Query, "FOR UPDATE") {
+		t.Fatalf("before query: %s", calls[1].Query)
+	}
+	args := calls[3].Args
+	if len(args) != 16 || args[1] != "admin" || args[2] != "null" || args[3] != `{"deleted_at":null,"id":1,"name":"a"}` {
+		t.Fatalf("audit args: %v", args)
+	}
+}
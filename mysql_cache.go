@@ -0,0 +1,283 @@
+package mcommon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// dbCacheClient 用于写操作后清除表缓存的redis 为nil时不清除
+var dbCacheClient *redis.Client
+
+// dbCacheFlights 正在执行的缓存查询
+var dbCacheFlights = struct {
+	sync.Mutex
+	m map[string]*dbCacheFlight
+}{
+	m: map[string]*dbCacheFlight{},
+}
+
+// dbCacheFlight 一次缓存查询 同key的并发请求共享结果
+type dbCacheFlight struct {
+	wg  sync.WaitGroup
+	bs  []byte
+	err error
+}
+
+// dbCachePending 事物中待清除缓存的表 事物提交后清除 回滚时丢弃
+var dbCachePending = struct {
+	sync.Mutex
+	m map[DbExeAble]map[string]bool
+}{
+	m: map[DbExeAble]map[string]bool{},
+}
+
+// dbCacheValue 缓存内容
+type dbCacheValue struct {
+	Ok   bool            `json:"ok"`
+	Data json.RawMessage `json:"data"`
+}
+
+// DbCacheSetClient 设置redis 设置后 KV 写函数执行成功时清除以表名为标签的缓存
+// 在 DbTransaction 开启的事物中执行时 事物提交后才清除
+func DbCacheSetClient(client *redis.Client) {
+	dbCacheClient = client
+}
+
+// DbCacheGetNamedContent 带缓存的 DbGetNamedContent 不存在的结果同样缓存
+// tags 为缓存标签 通常为查询涉及的表名
+func DbCacheGetNamedContent(ctx context.Context, client *redis.Client, key string, ttl time.Duration, tags []string, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}) (bool, error) {
+	return dbCacheDo(ctx, client, key, ttl, tags, dest, func(dest interface{}) (bool, error) {
+		return DbGetNamedContent(ctx, tx, dest, query, argMap)
+	})
+}
+
+// DbCacheSelectNamedContent 带缓存的 DbSelectNamedContent
+// tags 为缓存标签 通常为查询涉及的表名
+func DbCacheSelectNamedContent(ctx context.Context, client *redis.Client, key string, ttl time.Duration, tags []string, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}) error {
+	_, err := dbCacheDo(ctx, client, key, ttl, tags, dest, func(dest interface{}) (bool, error) {
+		err := DbSelectNamedContent(ctx, tx, dest, query, argMap)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	return err
+}
+
+// DbCacheInvalidateTags 清除标签下的所有缓存
+func DbCacheInvalidateTags(ctx context.Context, client *redis.Client, tags ...string) error {
+	c := client.WithContext(ctx)
+	for _, tag := range tags {
+		tagKey := dbCacheTagKey(tag)
+		keys, err := c.SMembers(tagKey).Result()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, tagKey)
+		err = c.Del(keys...).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbCacheInvalidateTable 写操作后清除表的缓存
+// 在 DbTransaction 开启的事物中时 等最外层事物提交后再清除
+// 避免其他请求在提交前读到旧数据并重新写入缓存
+func dbCacheInvalidateTable(ctx context.Context, tx DbExeAble, table string) {
+	if dbCacheClient == nil {
+		return
+	}
+	if dbCacheQueue(tx, table) {
+		return
+	}
+	if ctxTx, ok := DbTxFromContext(ctx); ok && dbCacheQueue(ctxTx, table) {
+		return
+	}
+	dbCacheInvalidate(ctx, []string{table})
+}
+
+// dbCacheQueue 事物在记录中时加入待清除的表
+func dbCacheQueue(tx DbExeAble, table string) bool {
+	if !dbHookKeyAble(tx) {
+		return false
+	}
+	dbCachePending.Lock()
+	defer dbCachePending.Unlock()
+	tables, ok := dbCachePending.m[tx]
+	if !ok {
+		return false
+	}
+	tables[table] = true
+	return true
+}
+
+// dbCacheTxBegin 开始记录事物中写入的表 返回事物结束时调用的函数
+// isCommit 为true时清除记录的表的缓存
+func dbCacheTxBegin(tx DbExeAble) func(ctx context.Context, isCommit bool) {
+	if !dbHookKeyAble(tx) {
+		return func(ctx context.Context, isCommit bool) {}
+	}
+	dbCachePending.Lock()
+	dbCachePending.m[tx] = map[string]bool{}
+	dbCachePending.Unlock()
+	return func(ctx context.Context, isCommit bool) {
+		dbCachePending.Lock()
+		tables := dbCachePending.m[tx]
+		delete(dbCachePending.m, tx)
+		dbCachePending.Unlock()
+		if !isCommit || len(tables) == 0 {
+			return
+		}
+		tags := make([]string, 0, len(tables))
+		for table := range tables {
+			tags = append(tags, table)
+		}
+		sort.Strings(tags)
+		dbCacheInvalidate(ctx, tags)
+	}
+}
+
+// dbCacheInvalidate 清除标签的缓存 失败时打印日志
+func dbCacheInvalidate(ctx context.Context, tags []string) {
+	client := dbCacheClient
+	if client == nil {
+		return
+	}
+	err := DbCacheInvalidateTags(ctx, client, tags...)
+	if err != nil {
+		Log.Warnf("db cache invalidate %s error: %s", strings.Join(tags, ","), err.Error())
+	}
+}
+
+// dbCacheTagKey 标签的redis key
+func dbCacheTagKey(tag string) string {
+	return redisKey("db_cache_tag_" + tag)
+}
+
+// dbCacheDo 读取缓存 未命中时执行查询并写入缓存
+func dbCacheDo(ctx context.Context, client *redis.Client, key string, ttl time.Duration, tags []string, dest interface{}, do func(dest interface{}) (bool, error)) (bool, error) {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return false, fmt.Errorf("dest type error: %T", dest)
+	}
+	key = "db_cache_" + key
+	cached, err := RedisGet(ctx, client, key)
+	if err != nil {
+		Log.Warnf("db cache get %s error: %s", key, err.Error())
+	}
+	if cached != "" {
+		ok, err := dbCacheDecode([]byte(cached), dest)
+		if err == nil {
+			return ok, nil
+		}
+		Log.Warnf("db cache decode %s error: %s", key, err.Error())
+	}
+
+	dbCacheFlights.Lock()
+	flight, ok := dbCacheFlights.m[key]
+	if ok {
+		dbCacheFlights.Unlock()
+		flight.wg.Wait()
+		if flight.err != nil {
+			return false, flight.err
+		}
+		return dbCacheDecode(flight.bs, dest)
+	}
+	flight = &dbCacheFlight{}
+	flight.wg.Add(1)
+	dbCacheFlights.m[key] = flight
+	dbCacheFlights.Unlock()
+
+	defer func() {
+		dbCacheFlights.Lock()
+		delete(dbCacheFlights.m, key)
+		dbCacheFlights.Unlock()
+		flight.wg.Done()
+	}()
+
+	isFound, err := do(dest)
+	if err != nil {
+		flight.err = err
+		return false, err
+	}
+	value := dbCacheValue{
+		Ok: isFound,
+	}
+	value.Data, err = json.Marshal(dest)
+	if err != nil {
+		flight.err = err
+		return false, err
+	}
+	flight.bs, err = json.Marshal(value)
+	if err != nil {
+		flight.err = err
+		return false, err
+	}
+	err = dbCacheSet(ctx, client, key, string(flight.bs), ttl, tags)
+	if err != nil {
+		Log.Warnf("db cache set %s error: %s", key, err.Error())
+	}
+	return isFound, nil
+}
+
+// dbCacheSet 写入缓存并记录标签
+func dbCacheSet(ctx context.Context, client *redis.Client, key string, value string, ttl time.Duration, tags []string) error {
+	err := RedisSet(ctx, client, key, value, ttl)
+	if err != nil {
+		return err
+	}
+	c := client.WithContext(ctx)
+	for _, tag := range tags {
+		tagKey := dbCacheTagKey(tag)
+		err = c.SAdd(tagKey, redisKey(key)).Err()
+		if err != nil {
+			return err
+		}
+		if ttl <= 0 {
+			err = c.Persist(tagKey).Err()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// 标签至少与缓存存活同样长
+		tagTTL, err := c.TTL(tagKey).Result()
+		if err != nil {
+			return err
+		}
+		if tagTTL < ttl {
+			err = c.Expire(tagKey, ttl).Err()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dbCacheDecode 解析缓存
+func dbCacheDecode(bs []byte, dest interface{}) (bool, error) {
+	var value dbCacheValue
+	err := json.Unmarshal(bs, &value)
+	if err != nil {
+		return false, err
+	}
+	if !value.Ok {
+		return false, nil
+	}
+	err = json.Unmarshal(value.Data, dest)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
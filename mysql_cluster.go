@@ -0,0 +1,220 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// DbClusterRoundRobin 轮询选择从库
+	DbClusterRoundRobin = iota
+	// DbClusterLeastConn 选择执行中查询最少的从库
+	DbClusterLeastConn
+)
+
+// dbPrimaryContextKey context中强制读主库的key
+type dbPrimaryContextKey struct{}
+
+// DbClusterOpt 集群选项
+type DbClusterOpt struct {
+	// Policy 从库选择策略 DbClusterRoundRobin 或 DbClusterLeastConn
+	Policy int
+	// CheckInterval 从库健康检查间隔 为0时为5秒
+	CheckInterval time.Duration
+	// CheckTimeout 从库ping超时 为0时为2秒
+	CheckTimeout time.Duration
+}
+
+// DbCluster 读写分离集群 读操作使用从库 写操作和事物使用主库
+type DbCluster struct {
+	primary  *sqlx.DB
+	replicas []*dbReplica
+	opt      DbClusterOpt
+	next     uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// dbReplica 从库
+type dbReplica struct {
+	db      *sqlx.DB
+	healthy int32
+	active  int64
+}
+
+// DbClusterCreate 创建读写分离集群 并启动从库健康检查
+func DbClusterCreate(primary *sqlx.DB, replicas []*sqlx.DB, opt *DbClusterOpt) *DbCluster {
+	c := &DbCluster{
+		primary: primary,
+		stop:    make(chan struct{}),
+	}
+	if opt != nil {
+		c.opt = *opt
+	}
+	if c.opt.CheckInterval <= 0 {
+		c.opt.CheckInterval = 5 * time.Second
+	}
+	if c.opt.CheckTimeout <= 0 {
+		c.opt.CheckTimeout = 2 * time.Second
+	}
+	for _, db := range replicas {
+		c.replicas = append(c.replicas, &dbReplica{
+			db:      db,
+			healthy: 1,
+		})
+	}
+	if len(c.replicas) > 0 {
+		go c.check()
+	}
+	return c
+}
+
+// DbContextUsePrimary 设置context强制读主库 用于写后读一致
+func DbContextUsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dbPrimaryContextKey{}, true)
+}
+
+// Primary 获取主库
+func (c *DbCluster) Primary() *sqlx.DB {
+	return c.primary
+}
+
+// Close 停止健康检查 不关闭数据库连接
+func (c *DbCluster) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+// Rebind 转换占位符
+func (c *DbCluster) Rebind(query string) string {
+	return c.primary.Rebind(query)
+}
+
+// Get 查询单行
+func (c *DbCluster) Get(dest interface{}, query string, args ...interface{}) error {
+	return c.GetContext(context.Background(), dest, query, args...)
+}
+
+// Exec 执行
+func (c *DbCluster) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.primary.Exec(query, args...)
+}
+
+// Select 查询多行
+func (c *DbCluster) Select(dest interface{}, query string, args ...interface{}) error {
+	return c.SelectContext(context.Background(), dest, query, args...)
+}
+
+// GetContext 查询单行
+func (c *DbCluster) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	r := c.pick(ctx)
+	if r == nil {
+		return c.primary.GetContext(ctx, dest, query, args...)
+	}
+	defer atomic.AddInt64(&r.active, -1)
+	return r.db.GetContext(ctx, dest, query, args...)
+}
+
+// ExecContext 执行
+func (c *DbCluster) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.primary.ExecContext(ctx, query, args...)
+}
+
+// SelectContext 查询多行
+func (c *DbCluster) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	r := c.pick(ctx)
+	if r == nil {
+		return c.primary.SelectContext(ctx, dest, query, args...)
+	}
+	defer atomic.AddInt64(&r.active, -1)
+	return r.db.SelectContext(ctx, dest, query, args...)
+}
+
+// QueryxContext 逐行查询
+// *sqlx.Rows 没有关闭回调 从库的活跃数在查询返回时即减少
+// 逐行读取期间不计入 DbClusterLeastConn 选择从库时比较的活跃数
+func (c *DbCluster) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	r := c.pick(ctx)
+	if r == nil {
+		return c.primary.QueryxContext(ctx, query, args...)
+	}
+	defer atomic.AddInt64(&r.active, -1)
+	return r.db.QueryxContext(ctx, query, args...)
+}
+
+// DbBeginTx 在主库上开启事物
+func (c *DbCluster) DbBeginTx(ctx context.Context, opts *sql.TxOptions) (DbTxAble, error) {
+	return c.primary.BeginTxx(ctx, opts)
+}
+
+// pick 选择一个健康的从库 没有时返回nil
+func (c *DbCluster) pick(ctx context.Context) *dbReplica {
+	if len(c.replicas) == 0 {
+		return nil
+	}
+	if usePrimary, _ := ctx.Value(dbPrimaryContextKey{}).(bool); usePrimary {
+		return nil
+	}
+	var picked *dbReplica
+	switch c.opt.Policy {
+	case DbClusterLeastConn:
+		var minActive int64
+		for _, r := range c.replicas {
+			if atomic.LoadInt32(&r.healthy) == 0 {
+				continue
+			}
+			active := atomic.LoadInt64(&r.active)
+			if picked == nil || active < minActive {
+				picked = r
+				minActive = active
+			}
+		}
+	default:
+		n := len(c.replicas)
+		start := int(atomic.AddUint64(&c.next, 1) % uint64(n))
+		for i := 0; i < n; i++ {
+			r := c.replicas[(start+i)%n]
+			if atomic.LoadInt32(&r.healthy) == 1 {
+				picked = r
+				break
+			}
+		}
+	}
+	if picked != nil {
+		atomic.AddInt64(&picked.active, 1)
+	}
+	return picked
+}
+
+// check 定时ping从库 失败时移出 恢复后加回
+func (c *DbCluster) check() {
+	ticker := time.NewTicker(c.opt.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		for i, r := range c.replicas {
+			ctx, cancel := context.WithTimeout(context.Background(), c.opt.CheckTimeout)
+			err := r.db.PingContext(ctx)
+			cancel()
+			if err != nil {
+				if atomic.SwapInt32(&r.healthy, 0) == 1 {
+					Log.Warnf("db replica %d evicted: %s", i, err.Error())
+				}
+				continue
+			}
+			if atomic.SwapInt32(&r.healthy, 1) == 0 {
+				Log.Infof("db replica %d recovered", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package mcommon
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DbOp 条件操作 作为 KV 函数 values 中的值使用
+// 如 keys: []string{"amount"} values: []interface{}{DbGte(10)}
+type DbOp struct {
+	Op     string
+	Value  interface{}
+	Value2 interface{}
+	Groups []DbWhere
+}
+
+// DbWhere 一组 AND 条件
+type DbWhere struct {
+	Keys   []string
+	Values []interface{}
+}
+
+// DbExpr 带命名参数的sql表达式 用于更新
+// 如 "balance": DbExpr{SQL: "balance - :amount", Args: H{"amount": 10}}
+type DbExpr struct {
+	SQL  string
+	Args H
+}
+
+// DbEq 等于 值为nil时为 IS NULL 值为数组时为 IN
+func DbEq(v interface{}) DbOp {
+	return DbOp{Op: "=", Value: v}
+}
+
+// DbNe 不等于 值为nil时为 IS NOT NULL 值为数组时为 NOT IN
+func DbNe(v interface{}) DbOp {
+	return DbOp{Op: "!=", Value: v}
+}
+
+// DbGt 大于
+func DbGt(v interface{}) DbOp {
+	return DbOp{Op: ">", Value: v}
+}
+
+// DbGte 大于等于
+func DbGte(v interface{}) DbOp {
+	return DbOp{Op: ">=", Value: v}
+}
+
+// DbLt 小于
+func DbLt(v interface{}) DbOp {
+	return DbOp{Op: "<", Value: v}
+}
+
+// DbLte 小于等于
+func DbLte(v interface{}) DbOp {
+	return DbOp{Op: "<=", Value: v}
+}
+
+// DbLike LIKE
+func DbLike(v string) DbOp {
+	return DbOp{Op: "LIKE", Value: v}
+}
+
+// DbNotLike NOT LIKE
+func DbNotLike(v string) DbOp {
+	return DbOp{Op: "NOT LIKE", Value: v}
+}
+
+// DbBetween BETWEEN 闭区间
+func DbBetween(min, max interface{}) DbOp {
+	return DbOp{Op: "BETWEEN", Value: min, Value2: max}
+}
+
+// DbIsNull IS NULL
+func DbIsNull() DbOp {
+	return DbOp{Op: "IS NULL"}
+}
+
+// DbIsNotNull IS NOT NULL
+func DbIsNotNull() DbOp {
+	return DbOp{Op: "IS NOT NULL"}
+}
+
+// DbOr 多组条件的 OR 对应的key不使用 可以为空字符串
+func DbOr(groups ...DbWhere) DbOp {
+	return DbOp{Op: "OR", Groups: groups}
+}
+
+// dbArgName 生成不重复的参数名
+func dbArgName(argMap H) string {
+	for i := len(argMap); ; i++ {
+		name := fmt.Sprintf("_p%d", i)
+		if _, ok := argMap[name]; !ok {
+			return name
+		}
+	}
+}
+
+// dbAddArg 添加参数 返回占位符
+func dbAddArg(argMap H, v interface{}) string {
+	name := dbArgName(argMap)
+	argMap[name] = v
+	return ":" + name
+}
+
+// dbIsSliceArg 是否为需要展开为IN的数组
+func dbIsSliceArg(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	if _, ok := v.([]byte); ok {
+		return false
+	}
+	return reflect.TypeOf(v).Kind() == reflect.Slice
+}
+
+// dbWriteKVWhere 写入where条件 值为空数组时返回false
+func dbWriteKVWhere(query *strings.Builder, argMap H, keys []string, values []interface{}) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("value len error")
+	}
+	for i, key := range keys {
+		if i != 0 {
+			query.WriteString("AND ")
+		}
+		ok, err := dbWriteKVCond(query, argMap, key, values[i], false)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+		query.WriteString("\n")
+	}
+	return true, nil
+}
+
+// dbWriteKVCond 写入单个条件 inGroup 为true时空数组写为恒假条件
+func dbWriteKVCond(query *strings.Builder, argMap H, key string, value interface{}, inGroup bool) (bool, error) {
+	op, ok := value.(DbOp)
+	if !ok {
+		op = DbEq(value)
+	}
+	if op.Op != "OR" && key == "" {
+		return false, fmt.Errorf("key empty error")
+	}
+	switch op.Op {
+	case "=", "!=":
+		if op.Value == nil {
+			query.WriteString(key)
+			if op.Op == "=" {
+				query.WriteString(" IS NULL")
+			} else {
+				query.WriteString(" IS NOT NULL")
+			}
+			return true, nil
+		}
+		if dbIsSliceArg(op.Value) {
+			if reflect.ValueOf(op.Value).Len() == 0 {
+				switch {
+				case op.Op == "!=":
+					query.WriteString("1=1")
+				case inGroup:
+					query.WriteString("1=0")
+				default:
+					return false, nil
+				}
+				return true, nil
+			}
+			query.WriteString(key)
+			if op.Op == "=" {
+				query.WriteString(" IN (")
+			} else {
+				query.WriteString(" NOT IN (")
+			}
+			query.WriteString(dbAddArg(argMap, op.Value))
+			query.WriteString(")")
+			return true, nil
+		}
+		query.WriteString(key)
+		query.WriteString(op.Op)
+		query.WriteString(dbAddArg(argMap, op.Value))
+	case ">", ">=", "<", "<=", "LIKE", "NOT LIKE":
+		if op.Value == nil {
+			return false, fmt.Errorf("%s %s value nil error", key, op.Op)
+		}
+		if dbIsSliceArg(op.Value) {
+			return false, fmt.Errorf("%s %s value type error: %T", key, op.Op, op.Value)
+		}
+		query.WriteString(key)
+		if strings.Contains(op.Op, "LIKE") {
+			query.WriteString(" ")
+			query.WriteString(op.Op)
+			query.WriteString(" ")
+		} else {
+			query.WriteString(op.Op)
+		}
+		query.WriteString(dbAddArg(argMap, op.Value))
+	case "BETWEEN":
+		if op.Value == nil || op.Value2 == nil {
+			return false, fmt.Errorf("%s BETWEEN value nil error", key)
+		}
+		query.WriteString(key)
+		query.WriteString(" BETWEEN ")
+		query.WriteString(dbAddArg(argMap, op.Value))
+		query.WriteString(" AND ")
+		query.WriteString(dbAddArg(argMap, op.Value2))
+	case "IS NULL", "IS NOT NULL":
+		query.WriteString(key)
+		query.WriteString(" ")
+		query.WriteString(op.Op)
+	case "OR":
+		if len(op.Groups) == 0 {
+			return false, fmt.Errorf("or groups len error")
+		}
+		query.WriteString("(")
+		for i, group := range op.Groups {
+			if len(group.Keys) == 0 {
+				return false, fmt.Errorf("or group keys len error")
+			}
+			if len(group.Keys) != len(group.Values) {
+				return false, fmt.Errorf("or group value len error")
+			}
+			if i != 0 {
+				query.WriteString(" OR ")
+			}
+			query.WriteString("(")
+			for j, k := range group.Keys {
+				if j != 0 {
+					query.WriteString(" AND ")
+				}
+				_, err := dbWriteKVCond(query, argMap, k, group.Values[j], true)
+				if err != nil {
+					return false, err
+				}
+			}
+			query.WriteString(")")
+		}
+		query.WriteString(")")
+	default:
+		return false, fmt.Errorf("op error: %s", op.Op)
+	}
+	return true, nil
+}
+
+// dbWriteKVSet 写入更新的列 值为 DbRaw 或 DbExpr 时作为表达式写入
+func dbWriteKVSet(query *strings.Builder, argMap H, updateMap H) error {
+	if len(updateMap) == 0 {
+		return fmt.Errorf("update map len error")
+	}
+	keys := make([]string, 0, len(updateMap))
+	for k := range updateMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i != 0 {
+			query.WriteString(",\n")
+		}
+		query.WriteString(k)
+		query.WriteString("=")
+		s, err := dbSetValue(argMap, updateMap[k])
+		if err != nil {
+			return err
+		}
+		query.WriteString(s)
+	}
+	query.WriteString("\n")
+	return nil
+}
+
+// dbSetValue 获取更新值的sql
+func dbSetValue(argMap H, v interface{}) (string, error) {
+	switch e := v.(type) {
+	case DbRaw:
+		return string(e), nil
+	case DbExpr:
+		for name, arg := range e.Args {
+			old, ok := argMap[name]
+			if ok && !reflect.DeepEqual(old, arg) {
+				return "", fmt.Errorf("expr arg duplicate: %s", name)
+			}
+			argMap[name] = arg
+		}
+		return e.SQL, nil
+	case DbOp:
+		return "", fmt.Errorf("update value type error: %T", v)
+	}
+	return dbAddArg(argMap, v), nil
+}
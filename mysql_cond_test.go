@@ -0,0 +1,93 @@
+package mcommon
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDbWriteKVCond(t *testing.T) {
+	cases := []struct {
+		key     string
+		value   interface{}
+		inGroup bool
+		sql     string
+		args    H
+		ok      bool
+	}{
+		{"a", 1, false, "a=:_p0", H{"_p0": 1}, true},
+		{"a", nil, false, "a IS NULL", H{}, true},
+		{"a", DbNe(nil), false, "a IS NOT NULL", H{}, true},
+		{"a", []int64{1, 2}, false, "a IN (:_p0)", H{"_p0": []int64{1, 2}}, true},
+		{"a", DbNe([]int64{1}), false, "a NOT IN (:_p0)", H{"_p0": []int64{1}}, true},
+		{"a", []int64{}, false, "", H{}, false},
+		{"a", []int64{}, true, "1=0", H{}, true},
+		{"a", DbNe([]int64{}), false, "1=1", H{}, true},
+		{"a", []byte("x"), false, "a=:_p0", H{"_p0": []byte("x")}, true},
+		{"a", DbGte(10), false, "a>=:_p0", H{"_p0": 10}, true},
+		{"a", DbLike("x%"), false, "a LIKE :_p0", H{"_p0": "x%"}, true},
+		{"a", DbBetween(1, 2), false, "a BETWEEN :_p0 AND :_p1", H{"_p0": 1, "_p1": 2}, true},
+		{"a", DbIsNotNull(), false, "a IS NOT NULL", H{}, true},
+		{
+			"",
+			DbOr(
+				DbWhere{Keys: []string{"a", "b"}, Values: []interface{}{1, []int64{}}},
+				DbWhere{Keys: []string{"c"}, Values: []interface{}{DbLt(3)}},
+			),
+			false,
+			"((a=:_p0 AND 1=0) OR (c<:_p1))",
+			H{"_p0": 1, "_p1": 3},
+			true,
+		},
+	}
+	for _, c := range cases {
+		var query strings.Builder
+		argMap := H{}
+		ok, err := dbWriteKVCond(&query, argMap, c.key, c.value, c.inGroup)
+		if err != nil {
+			t.Fatalf("%s %v: %s", c.key, c.value, err)
+		}
+		if ok != c.ok || query.String() != c.sql || !reflect.DeepEqual(argMap, c.args) {
+			t.Errorf("%s %v: got %v %q %v", c.key, c.value, ok, query.String(), argMap)
+		}
+	}
+}
+
+func TestDbWriteKVCondError(t *testing.T) {
+	cases := []struct {
+		key   string
+		value interface{}
+	}{
+		{"", 1},
+		{"a", DbGt(nil)},
+		{"a", DbGt([]int64{1})},
+		{"a", DbBetween(1, nil)},
+		{"a", DbOr()},
+		{"a", DbOr(DbWhere{Keys: []string{"b"}})},
+		{"a", DbOp{Op: "<>", Value: 1}},
+	}
+	for _, c := range cases {
+		var query strings.Builder
+		_, err := dbWriteKVCond(&query, H{}, c.key, c.value, false)
+		if err == nil {
+			t.Errorf("%s %v: want error", c.key, c.value)
+		}
+	}
+}
+
+func TestDbWriteKVWhere(t *testing.T) {
+	var query strings.Builder
+	argMap := H{}
+	ok, err := dbWriteKVWhere(&query, argMap, []string{"a", "b"}, []interface{}{1, DbLte(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || query.String() != "a=:_p0\nAND b<=:_p1\n" {
+		t.Fatalf("got %v %q", ok, query.String())
+	}
+
+	_, err = dbWriteKVWhere(&query, H{}, []string{"a"}, nil)
+	if err == nil {
+		t.Fatal("want value len error")
+	}
+}
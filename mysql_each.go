@@ -0,0 +1,166 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DbEachChunkSize 分块遍历的默认块大小
+const DbEachChunkSize = 1000
+
+// DbRowsAble 可逐行读取的数据库对象 *sqlx.DB *sqlx.Tx 实现了该接口
+type DbRowsAble interface {
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+}
+
+// DbEachNamedContent 执行sql查询并逐行回调 fn返回错误时停止
+// newDest 返回每行的接收对象 如结构体指针
+func DbEachNamedContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}, newDest func() interface{}, fn func(row interface{}) error) error {
+	tx, query, err := dbShardQuery(ctx, tx, query)
+	if err != nil {
+		return err
+	}
+	rowsTx, ok := tx.(DbRowsAble)
+	if !ok {
+		return fmt.Errorf("db type error: %T", tx)
+	}
+	query, args, err := dbNamedIn(tx, query, argMap)
+	if err != nil {
+		return err
+	}
+	var rows *sqlx.Rows
+	err = dbQuery(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		var err error
+		rows, err = rowsTx.QueryxContext(
+			ctx,
+			query,
+			args...,
+		)
+		return 0, err
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		dest := newDest()
+		err = dbScanRow(rows, dest)
+		if err != nil {
+			return err
+		}
+		err = fn(dest)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DbEachChunkKV 按主键分块遍历表 每块单独查询 不需要长时间占用连接
+// newDest 返回结构体数组指针 结构体中需要有pk列 opt.Limit 为块大小 opt.Columns 中没有pk时会自动加上
+func DbEachChunkKV(ctx context.Context, tx DbExeAble, table string, pk string, keys []string, values []interface{}, opt *DbSelectOpt, newDest func() interface{}, fn func(dest interface{}) error) error {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	chunkOpt := DbSelectOpt{}
+	if opt != nil {
+		chunkOpt = *opt
+	}
+	if chunkOpt.Limit <= 0 {
+		chunkOpt.Limit = DbEachChunkSize
+	}
+	chunkOpt.Offset = 0
+	chunkOpt.OrderBy = []string{pk + " ASC"}
+	if len(chunkOpt.Columns) > 0 && !IsStringInSlice(chunkOpt.Columns, pk) {
+		// 没有pk列时无法翻页
+		chunkOpt.Columns = append(append([]string{}, chunkOpt.Columns...), pk)
+	}
+
+	var last interface{}
+	for {
+		chunkKeys := keys
+		chunkValues := values
+		if last != nil {
+			chunkKeys = append(append([]string{}, keys...), pk)
+			chunkValues = append(append([]interface{}{}, values...), DbGt(last))
+		}
+		query, argMap, ok, err := dbBuildSelectKV(table, chunkKeys, chunkValues, &chunkOpt)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		dest := newDest()
+		err = DbSelectNamedContent(
+			ctx,
+			tx,
+			dest,
+			query,
+			argMap,
+		)
+		if err != nil {
+			return err
+		}
+		rv := reflect.Indirect(reflect.ValueOf(dest))
+		if rv.Kind() != reflect.Slice {
+			return fmt.Errorf("dest type error: %T", dest)
+		}
+		n := rv.Len()
+		if n == 0 {
+			return nil
+		}
+		err = fn(dest)
+		if err != nil {
+			return err
+		}
+		if int64(n) < chunkOpt.Limit {
+			return nil
+		}
+		last, err = dbStructValue(rv.Index(n-1), pk)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dbStructValue 获取结构体中对应列的值
+func dbStructValue(row reflect.Value, column string) (interface{}, error) {
+	row = reflect.Indirect(row)
+	if row.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("row type error: %s", row.Type())
+	}
+	columns, indexes := dbStructColumns(row.Type(), nil)
+	for i, c := range columns {
+		if c == column {
+			return row.FieldByIndex(indexes[i]).Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("row miss column: %s", column)
+}
+
+// dbScanRow 读取一行 结构体使用StructScan map使用MapScan 其他使用Scan
+func dbScanRow(rows *sqlx.Rows, dest interface{}) error {
+	if m, ok := dest.(*map[string]interface{}); ok {
+		if *m == nil {
+			*m = map[string]interface{}{}
+		}
+		return rows.MapScan(*m)
+	}
+	if _, ok := dest.(sql.Scanner); ok {
+		return rows.Scan(dest)
+	}
+	t := reflect.TypeOf(dest)
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		columns, _ := dbStructColumns(t.Elem(), nil)
+		if len(columns) > 0 {
+			return rows.StructScan(dest)
+		}
+	}
+	return rows.Scan(dest)
+}
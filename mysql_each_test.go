@@ -0,0 +1,79 @@
+package mcommon
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+type eachUser struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+// testSelectDb 按调用顺序返回预设行
+type testSelectDb struct {
+	DbExeAble
+	queries []string
+	args    [][]interface{}
+	rows    [][]*eachUser
+}
+
+func (db *testSelectDb) Rebind(query string) string {
+	return query
+}
+
+func (db *testSelectDb) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.queries = append(db.queries, query)
+	db.args = append(db.args, args)
+	if len(db.rows) > 0 {
+		*dest.(*[]*eachUser) = db.rows[0]
+		db.rows = db.rows[1:]
+	}
+	return nil
+}
+
+func TestDbEachChunkKV(t *testing.T) {
+	db := &testSelectDb{
+		rows: [][]*eachUser{
+			{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}},
+			{{ID: 3, Name: "c"}},
+		},
+	}
+
+	var names []string
+	err := DbEachChunkKV(
+		context.Background(),
+		db,
+		"t_user",
+		"id",
+		[]string{"status"},
+		[]interface{}{1},
+		&DbSelectOpt{
+			Columns: []string{"name"},
+			Limit:   2,
+		},
+		func() interface{} {
+			return &[]*eachUser{}
+		},
+		func(dest interface{}) error {
+			for _, user := range *dest.(*[]*eachUser) {
+				names = append(names, user.Name)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || names[2] != "c" {
+		t.Fatalf("names: %v", names)
+	}
+	if len(db.queries) != 2 || db.args[1][1] != int64(2) {
+		t.Fatalf("queries: %q %v", db.queries, db.args)
+	}
+	// 未选择的主键列自动加入
+	if !regexp.MustCompile(`^SELECT\s+name,\s+id\s+FROM`).MatchString(db.queries[0]) {
+		t.Fatalf("query: %s", db.queries[0])
+	}
+}
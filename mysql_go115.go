@@ -0,0 +1,19 @@
+//go:build go1.15
+// +build go1.15
+
+package mcommon
+
+import (
+	"database/sql"
+	"time"
+)
+
+// dbSetConnMaxIdleTime 设置连接最大空闲时间
+func dbSetConnMaxIdleTime(db *sql.DB, d time.Duration) {
+	db.SetConnMaxIdleTime(d)
+}
+
+// dbMaxIdleTimeClosed 因超过最大空闲时间关闭的连接数
+func dbMaxIdleTimeClosed(s sql.DBStats) int64 {
+	return s.MaxIdleTimeClosed
+}
@@ -0,0 +1,202 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DbQueryEvent sql执行结果
+type DbQueryEvent struct {
+	// Query 执行的语句
+	Query string
+	// Args 参数
+	Args []interface{}
+	// Duration 耗时
+	Duration time.Duration
+	// Rows 查询为返回行数 执行为影响行数
+	Rows int64
+	// Err 执行错误
+	Err error
+}
+
+// DbQueryHook sql执行钩子
+type DbQueryHook interface {
+	// Before 执行前调用 返回的context会传给执行和After
+	Before(ctx context.Context, query string, args []interface{}) context.Context
+	// After 执行后调用
+	After(ctx context.Context, event *DbQueryEvent)
+}
+
+// dbHooks 各个数据库对象注册的钩子
+var dbHooks = struct {
+	sync.RWMutex
+	hooks   map[DbExeAble][]DbQueryHook
+	parents map[DbExeAble]DbExeAble
+}{
+	hooks:   map[DbExeAble][]DbQueryHook{},
+	parents: map[DbExeAble]DbExeAble{},
+}
+
+// DbAddQueryHook 为数据库对象注册钩子 通过 DbTransaction 开启的事物同样生效
+func DbAddQueryHook(db DbExeAble, hook DbQueryHook) {
+	if !dbHookKeyAble(db) {
+		Log.Warnf("db hook key type error: %T", db)
+		return
+	}
+	dbHooks.Lock()
+	defer dbHooks.Unlock()
+	dbHooks.hooks[db] = append(dbHooks.hooks[db], hook)
+}
+
+// DbRemoveQueryHooks 删除数据库对象的所有钩子
+func DbRemoveQueryHooks(db DbExeAble) {
+	if !dbHookKeyAble(db) {
+		return
+	}
+	dbHooks.Lock()
+	defer dbHooks.Unlock()
+	delete(dbHooks.hooks, db)
+}
+
+// dbHookKeyAble 是否可以作为map的key
+func dbHookKeyAble(db DbExeAble) bool {
+	return db != nil && reflect.TypeOf(db).Comparable()
+}
+
+// dbLinkTx 记录事物所属的数据库对象 返回解除关联的函数
+func dbLinkTx(tx DbExeAble, db DbExeAble) func() {
+	if !dbHookKeyAble(tx) || !dbHookKeyAble(db) {
+		return func() {}
+	}
+	dbHooks.Lock()
+	defer dbHooks.Unlock()
+	dbHooks.parents[tx] = db
+	return func() {
+		dbHooks.Lock()
+		defer dbHooks.Unlock()
+		delete(dbHooks.parents, tx)
+	}
+}
+
+// dbTxParent 获取 DbTransaction 开启的事物所属的数据库对象
+func dbTxParent(tx DbExeAble) (DbExeAble, bool) {
+	if !dbHookKeyAble(tx) {
+		return nil, false
+	}
+	dbHooks.RLock()
+	defer dbHooks.RUnlock()
+	db, ok := dbHooks.parents[tx]
+	return db, ok
+}
+
+// dbGetHooks 获取数据库对象的钩子
+func dbGetHooks(tx DbExeAble) []DbQueryHook {
+	if !dbHookKeyAble(tx) {
+		return nil
+	}
+	dbHooks.RLock()
+	defer dbHooks.RUnlock()
+	for tx != nil {
+		hooks, ok := dbHooks.hooks[tx]
+		if ok {
+			return hooks
+		}
+		tx = dbHooks.parents[tx]
+	}
+	return nil
+}
+
+// dbQuery 执行sql并调用钩子
+func dbQuery(ctx context.Context, tx DbExeAble, query string, args []interface{}, do func(ctx context.Context) (int64, error)) error {
+	hooks := dbGetHooks(tx)
+	for _, hook := range hooks {
+		ctx = hook.Before(ctx, query, args)
+	}
+	start := time.Now()
+	rows, err := do(ctx)
+	du := time.Since(start)
+	dbRecordQuery(query, args, du, err)
+	if len(hooks) > 0 {
+		event := &DbQueryEvent{
+			Query:    query,
+			Args:     args,
+			Duration: du,
+			Rows:     rows,
+			Err:      err,
+		}
+		for _, hook := range hooks {
+			hook.After(ctx, event)
+		}
+	}
+	return err
+}
+
+// DbInterpolateSQL 把参数填入sql 仅用于日志显示
+func DbInterpolateSQL(query string, args []interface{}) string {
+	queryStr := query + ";"
+	for _, arg := range args {
+		_, ok := arg.(string)
+		if ok {
+			queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`"%s"`, arg), 1)
+		} else {
+			queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`%v`, arg), 1)
+		}
+	}
+	return queryStr
+}
+
+// dbDebugHook 默认的debug钩子 DbCreate 的 showSQL 为true时注册
+var dbDebugHook = &DbDebugHook{}
+
+// DbDebugHook 打印sql并记录执行次数的钩子
+type DbDebugHook struct {
+	lock     sync.Mutex
+	sqlMap   map[string]string
+	countMap map[string]int64
+}
+
+// Before 打印并记录sql
+func (h *DbDebugHook) Before(ctx context.Context, query string, args []interface{}) context.Context {
+	queryStr := DbInterpolateSQL(query, args)
+	Log.Debugf(queryStr)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.sqlMap == nil {
+		h.sqlMap = make(map[string]string)
+		h.countMap = make(map[string]int64)
+	}
+	h.sqlMap[query] = queryStr
+	h.countMap[query]++
+	return ctx
+}
+
+// After 无操作
+func (h *DbDebugHook) After(ctx context.Context, event *DbQueryEvent) {
+}
+
+// getSQLMap 获取sql记录的拷贝
+func (h *DbDebugHook) getSQLMap() map[string]string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	m := make(map[string]string, len(h.sqlMap))
+	for k, v := range h.sqlMap {
+		m[k] = v
+	}
+	return m
+}
+
+// getCountMap 获取sql次数的拷贝
+func (h *DbDebugHook) getCountMap() map[string]int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	m := make(map[string]int64, len(h.countMap))
+	for k, v := range h.countMap {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,392 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// DbInsertMaxPlaceholders mysql单条语句占位符上限
+	DbInsertMaxPlaceholders = 65535
+	// DbInsertMaxBytes 单批次默认字节上限 小于max_allowed_packet的默认值
+	DbInsertMaxBytes = 1024 * 1024
+)
+
+const (
+	// DbUpsertUnchanged DbUpsertKV 行已存在且未变化
+	DbUpsertUnchanged = 0
+	// DbUpsertInserted DbUpsertKV 插入
+	DbUpsertInserted = 1
+	// DbUpsertUpdated DbUpsertKV 更新
+	DbUpsertUpdated = 2
+)
+
+// DbInsertOpt 批量插入选项
+type DbInsertOpt struct {
+	// MaxPlaceholders 每批次占位符上限 为0时为 DbInsertMaxPlaceholders
+	MaxPlaceholders int
+	// MaxBytes 每批次参数字节上限 为0时为 DbInsertMaxBytes
+	MaxBytes int
+	// Transaction 是否在一个事物中执行 tx为事物时使用保存点
+	Transaction bool
+}
+
+// DbInsertKV 插入单行并返回lastID
+func DbInsertKV(ctx context.Context, tx DbExeAble, table string, insertMap H) (int64, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+	if len(insertMap) == 0 {
+		return 0, fmt.Errorf("insert map len error")
+	}
+	keys := make([]string, 0, len(insertMap))
+	for k := range insertMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	query := strings.Builder{}
+	query.WriteString("INSERT INTO ")
+	query.WriteString(table)
+	query.WriteString(" (\n")
+	query.WriteString(strings.Join(keys, ",\n"))
+	query.WriteString("\n) VALUES (\n:")
+	query.WriteString(strings.Join(keys, ",\n:"))
+	query.WriteString("\n)")
+
+	lastID, err := DbExecuteLastIDNamedContent(
+		ctx,
+		tx,
+		query.String(),
+		insertMap,
+	)
+	if err != nil {
+		return 0, err
+	}
+	dbCacheInvalidateTable(ctx, tx, table)
+	return lastID, nil
+}
+
+// DbInsertMany 批量插入 rows 为结构体(db tag)数组或 H 数组
+// 返回总插入行数以及每批次的首个插入id
+func DbInsertMany(ctx context.Context, tx DbExeAble, table string, rows interface{}, opt *DbInsertOpt) (int64, []int64, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return 0, nil, err
+	}
+	return dbInsertManyContent(ctx, tx, table, rows, "", nil, opt)
+}
+
+// dbInsertManyContent 批量插入 suffix 为追加在 VALUES 之后的语句
+func dbInsertManyContent(ctx context.Context, tx DbExeAble, table string, rows interface{}, suffix string, suffixArgs []interface{}, opt *DbInsertOpt) (int64, []int64, error) {
+	if opt == nil {
+		opt = &DbInsertOpt{}
+	}
+	columns, rowArgs, err := dbInsertRows(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(rowArgs) == 0 {
+		return 0, nil, nil
+	}
+	batches, err := dbInsertBatches(len(columns), len(suffixArgs), len(suffix), rowArgs, opt)
+	if err != nil {
+		return 0, nil, err
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (\n%s\n) VALUES %%s%s",
+		table,
+		strings.Join(columns, ",\n"),
+		strings.Replace(suffix, "%", "%%", -1),
+	)
+
+	var count int64
+	var firstIDs []int64
+	doInsert := func(dbTx DbExeAble) error {
+		count = 0
+		firstIDs = nil
+		for _, batch := range batches {
+			args := append(batch, suffixArgs...)
+			ret, err := dbExecuteManyContent(ctx, dbTx, query, len(batch), args...)
+			if err != nil {
+				return err
+			}
+			batchCount, err := ret.RowsAffected()
+			if err != nil {
+				return err
+			}
+			firstID, err := ret.LastInsertId()
+			if err != nil {
+				return err
+			}
+			count += batchCount
+			firstIDs = append(firstIDs, firstID)
+		}
+		return nil
+	}
+	if opt.Transaction {
+		err = DbTransaction(ctx, tx, doInsert)
+	} else {
+		err = doInsert(tx)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	dbCacheInvalidateTable(ctx, tx, table)
+	return count, firstIDs, nil
+}
+
+// dbInsertBatches 按占位符数和字节数拆分批次
+// extraArgLen extraBytes 为每批次固定追加的占位符数和字节数
+func dbInsertBatches(columnLen, extraArgLen, extraBytes int, rowArgs [][]interface{}, opt *DbInsertOpt) ([][]interface{}, error) {
+	maxPlaceholders := opt.MaxPlaceholders
+	if maxPlaceholders <= 0 || maxPlaceholders > DbInsertMaxPlaceholders {
+		maxPlaceholders = DbInsertMaxPlaceholders
+	}
+	maxBytes := opt.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DbInsertMaxBytes
+	}
+	maxPlaceholders -= extraArgLen
+	maxBytes -= extraBytes
+	maxRows := maxPlaceholders / columnLen
+	if maxRows == 0 {
+		return nil, fmt.Errorf("column len error: %d", columnLen)
+	}
+
+	var batches [][]interface{}
+	var batch []interface{}
+	var batchBytes int
+	for _, args := range rowArgs {
+		rowBytes := dbInsertArgsBytes(args)
+		if len(batch) > 0 && (len(batch) >= maxRows || batchBytes+rowBytes > maxBytes) {
+			batches = append(batches, batch)
+			batch = nil
+			batchBytes = 0
+		}
+		batch = append(batch, args)
+		batchBytes += rowBytes
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+// dbInsertArgsBytes 估算一行参数的字节数
+func dbInsertArgsBytes(args []interface{}) int {
+	// (?,?)
+	n := len(args)*2 + 2
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			n += len(v)
+		case []byte:
+			n += len(v)
+		default:
+			n += 8
+		}
+	}
+	return n
+}
+
+// dbInsertRows 解析插入的列和每行的参数
+func dbInsertRows(rows interface{}) ([]string, [][]interface{}, error) {
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice {
+		return nil, nil, fmt.Errorf("rows type error: %T", rows)
+	}
+	if rv.Len() == 0 {
+		return nil, nil, nil
+	}
+	var columns []string
+	var fieldIndexes [][]int
+	var rowType reflect.Type
+	rowArgs := make([][]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		row := reflect.Indirect(rv.Index(i))
+		if row.Kind() == reflect.Interface {
+			row = reflect.Indirect(row.Elem())
+		}
+		if !row.IsValid() {
+			return nil, nil, fmt.Errorf("row %d is nil", i)
+		}
+		switch row.Kind() {
+		case reflect.Map:
+			m, ok := dbInsertMap(row)
+			if !ok {
+				return nil, nil, fmt.Errorf("row type error: %s", row.Type())
+			}
+			if columns == nil {
+				for k := range m {
+					columns = append(columns, k)
+				}
+				sort.Strings(columns)
+				if len(columns) == 0 {
+					return nil, nil, fmt.Errorf("row %d columns len error", i)
+				}
+			}
+			if len(m) != len(columns) {
+				return nil, nil, fmt.Errorf("row %d columns not match", i)
+			}
+			args := make([]interface{}, 0, len(columns))
+			for _, column := range columns {
+				v, ok := m[column]
+				if !ok {
+					return nil, nil, fmt.Errorf("row %d miss column: %s", i, column)
+				}
+				args = append(args, v)
+			}
+			rowArgs = append(rowArgs, args)
+		case reflect.Struct:
+			if i == 0 {
+				rowType = row.Type()
+				columns, fieldIndexes = dbStructColumns(rowType, nil)
+				if len(columns) == 0 {
+					return nil, nil, fmt.Errorf("row %d columns len error", i)
+				}
+			} else if row.Type() != rowType {
+				// 与第一行比较解开指针和interface后的类型
+				return nil, nil, fmt.Errorf("row %d type not match", i)
+			}
+			args := make([]interface{}, 0, len(columns))
+			for _, index := range fieldIndexes {
+				args = append(args, row.FieldByIndex(index).Interface())
+			}
+			rowArgs = append(rowArgs, args)
+		default:
+			return nil, nil, fmt.Errorf("row type error: %s", row.Type())
+		}
+	}
+	return columns, rowArgs, nil
+}
+
+// dbInsertMap 转换map行
+func dbInsertMap(row reflect.Value) (map[string]interface{}, bool) {
+	if row.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	m := make(map[string]interface{}, row.Len())
+	iter := row.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = iter.Value().Interface()
+	}
+	return m, true
+}
+
+// dbStructColumns 获取结构体的列名和字段索引 规则与sqlx一致
+func dbStructColumns(t reflect.Type, parent []int) ([]string, [][]int) {
+	var columns []string
+	var indexes [][]int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		if field.Anonymous && tag == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Struct {
+				subColumns, subIndexes := dbStructColumns(ft, index)
+				columns = append(columns, subColumns...)
+				indexes = append(indexes, subIndexes...)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		columns = append(columns, name)
+		indexes = append(indexes, index)
+	}
+	return columns, indexes
+}
+
+// DbUpsertKV 插入或更新 INSERT ... ON DUPLICATE KEY UPDATE
+// updateMap 的值为 DbRaw 或 DbExpr 时作为表达式写入 如 "count": DbRaw("count + VALUES(count)")
+// 返回lastID以及结果 DbUpsertInserted DbUpsertUpdated 或 DbUpsertUnchanged
+// DSN 中设置了 clientFoundRows=true 时无法区分未变化和插入
+func DbUpsertKV(ctx context.Context, tx DbExeAble, table string, insertMap H, updateMap H) (int64, int64, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(insertMap) == 0 {
+		return 0, 0, fmt.Errorf("insert map len error")
+	}
+	keys := make([]string, 0, len(insertMap))
+	for k := range insertMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	argMap := H{}
+	for k, v := range insertMap {
+		argMap[k] = v
+	}
+
+	query := strings.Builder{}
+	query.WriteString("INSERT INTO ")
+	query.WriteString(table)
+	query.WriteString(" (\n")
+	query.WriteString(strings.Join(keys, ",\n"))
+	query.WriteString("\n) VALUES (\n:")
+	query.WriteString(strings.Join(keys, ",\n:"))
+	query.WriteString("\n)\nON DUPLICATE KEY UPDATE\n")
+	err = dbWriteKVSet(&query, argMap, updateMap)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ret, err := dbExecuteNamedContent(ctx, tx, query.String(), argMap)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, err := ret.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+	lastID, err := ret.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	if count > 0 {
+		dbCacheInvalidateTable(ctx, tx, table)
+	}
+	return lastID, count, nil
+}
+
+// DbUpsertMany 批量插入或更新 分批规则与 DbInsertMany 一致
+// 返回mysql的影响行数 插入的行计1 更新的行计2
+func DbUpsertMany(ctx context.Context, tx DbExeAble, table string, rows interface{}, updateMap H, opt *DbInsertOpt) (int64, error) {
+	tx, table, err := dbShardTable(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+	argMap := H{}
+	update := strings.Builder{}
+	update.WriteString("\nON DUPLICATE KEY UPDATE\n")
+	err = dbWriteKVSet(&update, argMap, updateMap)
+	if err != nil {
+		return 0, err
+	}
+	suffix, suffixArgs, err := sqlx.Named(update.String(), map[string]interface{}(argMap))
+	if err != nil {
+		return 0, err
+	}
+	count, _, err := dbInsertManyContent(ctx, tx, table, rows, suffix, suffixArgs, opt)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,77 @@
+package mcommon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDbInsertBatches(t *testing.T) {
+	var rowArgs [][]interface{}
+	for i := 0; i < 10; i++ {
+		rowArgs = append(rowArgs, []interface{}{i, "name"})
+	}
+
+	// 每批次 (7-1)/2=3 行
+	batches, err := dbInsertBatches(2, 1, 0, rowArgs, &DbInsertOpt{MaxPlaceholders: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	for _, batch := range batches {
+		sizes = append(sizes, len(batch))
+	}
+	if len(sizes) != 4 || sizes[0] != 3 || sizes[3] != 1 {
+		t.Fatalf("sizes: %v", sizes)
+	}
+
+	// 每行 2*2+2+8+4=18 字节
+	batches, err = dbInsertBatches(2, 0, 10, rowArgs, &DbInsertOpt{MaxBytes: 10 + 18*4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 3 || len(batches[0]) != 4 || len(batches[2]) != 2 {
+		t.Fatalf("batches: %v", batches)
+	}
+
+	// 超过字节上限的单行单独一批
+	big := [][]interface{}{{strings.Repeat("x", 100)}, {"a"}, {"b"}}
+	batches, err = dbInsertBatches(1, 0, 0, big, &DbInsertOpt{MaxBytes: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 1 || len(batches[1]) != 2 {
+		t.Fatalf("batches: %v", batches)
+	}
+
+	_, err = dbInsertBatches(8, 0, 0, rowArgs, &DbInsertOpt{MaxPlaceholders: 7})
+	if err == nil {
+		t.Fatal("want column len error")
+	}
+}
+
+func TestDbInsertRows(t *testing.T) {
+	type row struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+
+	columns, rowArgs, err := dbInsertRows([]interface{}{row{ID: 1, Name: "a"}, &row{ID: 2, Name: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 2 || len(rowArgs) != 2 || rowArgs[1][0] != int64(2) {
+		t.Fatalf("got %v %v", columns, rowArgs)
+	}
+
+	for _, rows := range []interface{}{
+		[]*row{{ID: 1}, nil},
+		[]interface{}{row{ID: 1}, nil},
+		[]interface{}{row{ID: 1}, H{"id": 2}},
+		[]interface{}{H{"id": 1}, row{ID: 2}},
+	} {
+		_, _, err := dbInsertRows(rows)
+		if err == nil {
+			t.Errorf("%v: want error", rows)
+		}
+	}
+}
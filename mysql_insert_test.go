@@ -0,0 +1,73 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// testExecDb 记录执行的语句
+type testExecDb struct {
+	DbExeAble
+	query string
+	args  []interface{}
+}
+
+func (db *testExecDb) Rebind(query string) string {
+	return query
+}
+
+func (db *testExecDb) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db.query = query
+	db.args = args
+	return testResult(3), nil
+}
+
+// testResult 返回固定的影响行数
+type testResult int64
+
+func (r testResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r testResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+func TestDbUpsertMany(t *testing.T) {
+	db := &testExecDb{}
+	count, err := DbUpsertMany(
+		context.Background(),
+		db,
+		"t_user",
+		[]H{
+			{"id": 1, "name": "a"},
+			{"id": 2, "name": "b"},
+		},
+		H{
+			"name":       DbRaw("VALUES(name)"),
+			"updated_at": 100,
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("count: %d", count)
+	}
+	if !strings.Contains(db.query, "ON DUPLICATE KEY UPDATE") ||
+		!strings.Contains(db.query, "name=VALUES(name)") ||
+		!strings.Contains(db.query, "updated_at=?") {
+		t.Fatalf("query: %s", db.query)
+	}
+	if len(db.args) != 5 || db.args[4] != 100 {
+		t.Fatalf("args: %v", db.args)
+	}
+
+	_, err = DbUpsertMany(context.Background(), db, "t_user", []H{{"id": 1}}, nil, nil)
+	if err == nil {
+		t.Fatal("want update map len error")
+	}
+}
@@ -0,0 +1,176 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+var (
+	// ErrDbLockTimeout 获取锁超时
+	ErrDbLockTimeout = errors.New("db lock timeout")
+	// ErrDbLockLost 锁已丢失 通常为连接断开
+	ErrDbLockLost = errors.New("db lock lost")
+)
+
+// DbWithLock 使用 GET_LOCK 获取mysql锁 并在同一个连接上执行fn
+// 无论fn返回错误 panic 或 context取消 都会执行 RELEASE_LOCK
+func DbWithLock(ctx context.Context, db *sqlx.DB, name string, timeout time.Duration, fn func(ctx context.Context, conn DbExeAble) error) error {
+	if timeout < 0 {
+		timeout = 0
+	}
+	return dbWithLock(ctx, db, name, timeout, fn)
+}
+
+// DbTryWithLock 尝试获取mysql锁 锁被占用时立即返回 ErrDbLockTimeout
+func DbTryWithLock(ctx context.Context, db *sqlx.DB, name string, fn func(ctx context.Context, conn DbExeAble) error) error {
+	return dbWithLock(ctx, db, name, 0, fn)
+}
+
+// dbWithLock 获取锁并执行
+func dbWithLock(ctx context.Context, db *sqlx.DB, name string, timeout time.Duration, fn func(ctx context.Context, conn DbExeAble) error) (err error) {
+	sqlConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sqlConn.Close()
+	conn := &dbConn{
+		conn:     sqlConn,
+		mapper:   db.Mapper,
+		bindType: sqlx.BindType(db.DriverName()),
+	}
+
+	var ret sql.NullInt64
+	err = conn.GetContext(ctx, &ret, "SELECT GET_LOCK(?, ?)", name, timeout.Seconds())
+	if err != nil {
+		return err
+	}
+	if !ret.Valid {
+		return fmt.Errorf("db get lock %s error", name)
+	}
+	if ret.Int64 != 1 {
+		return ErrDbLockTimeout
+	}
+	defer func() {
+		var released sql.NullInt64
+		releaseErr := conn.GetContext(context.Background(), &released, "SELECT RELEASE_LOCK(?)", name)
+		if releaseErr != nil {
+			Log.Warnf("db release lock %s error: %s", name, releaseErr.Error())
+		}
+		if err != nil {
+			if dbIsConnErr(err) {
+				err = fmt.Errorf("%w: %s", ErrDbLockLost, err.Error())
+			}
+			return
+		}
+		if releaseErr != nil || !released.Valid || released.Int64 != 1 {
+			err = ErrDbLockLost
+		}
+	}()
+	err = fn(ctx, conn)
+	return err
+}
+
+// dbIsConnErr 是否为连接断开的错误
+func dbIsConnErr(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, sql.ErrConnDone)
+}
+
+// dbConn 把 *sql.Conn 转换为 DbExeAble
+type dbConn struct {
+	conn     *sql.Conn
+	mapper   *reflectx.Mapper
+	bindType int
+}
+
+// Rebind 转换占位符
+func (c *dbConn) Rebind(query string) string {
+	return sqlx.Rebind(c.bindType, query)
+}
+
+// Get 查询单行
+func (c *dbConn) Get(dest interface{}, query string, args ...interface{}) error {
+	return c.GetContext(context.Background(), dest, query, args...)
+}
+
+// Exec 执行
+func (c *dbConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+// Select 查询多行
+func (c *dbConn) Select(dest interface{}, query string, args ...interface{}) error {
+	return c.SelectContext(context.Background(), dest, query, args...)
+}
+
+// GetContext 查询单行
+func (c *dbConn) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	rows, err := c.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		err = rows.Err()
+		if err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	err = dbScanRow(rows, dest)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// ExecContext 执行
+func (c *dbConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(ctx, query, args...)
+}
+
+// SelectContext 查询多行
+func (c *dbConn) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	rows, err := c.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return sqlx.StructScan(rows, dest)
+}
+
+// QueryxContext 逐行查询
+func (c *dbConn) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	rows, err := c.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlx.Rows{Rows: rows, Mapper: c.mapper}, nil
+}
+
+// DbBeginTx 在该连接上开启事物
+func (c *dbConn) DbBeginTx(ctx context.Context, opts *sql.TxOptions) (DbTxAble, error) {
+	tx, err := c.conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlx.Tx{Tx: tx, Mapper: c.mapper}, nil
+}
+
+// dbConnReset 重置执行sql文件的连接 回滚未提交的事物并恢复外键检查
+// 避免sql中途失败时 BEGIN 和 SET FOREIGN_KEY_CHECKS = 0 随连接回到连接池
+func dbConnReset(conn DbExeAble) {
+	for _, query := range []string{"ROLLBACK", "SET FOREIGN_KEY_CHECKS = 1"} {
+		_, err := conn.ExecContext(context.Background(), query)
+		if err != nil {
+			Log.Warnf("db conn reset %s error: %s", query, err.Error())
+		}
+	}
+}
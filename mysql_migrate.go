@@ -0,0 +1,487 @@
+package mcommon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DbMigrationTable 默认的迁移记录表名
+const DbMigrationTable = "schema_migrations"
+
+// dbMigrationFileRe 迁移文件名 如 20201018120000_add_user.up.sql
+var dbMigrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// DbMigration 迁移 UpSQL DownSQL 与 Up Down 二选一
+// sql迁移逐条执行 执行前记录为dirty 全部成功后清除 go迁移与迁移记录在同一个事物中执行
+type DbMigration struct {
+	Version int64
+	Name    string
+	UpSQL   string
+	DownSQL string
+	Up      func(ctx context.Context, tx DbExeAble) error
+	Down    func(ctx context.Context, tx DbExeAble) error
+}
+
+// DbMigrationStatus 迁移状态
+type DbMigrationStatus struct {
+	Version   int64  `json:"version"`
+	Name      string `json:"name"`
+	Applied   bool   `json:"applied"`
+	AppliedAt int64  `json:"applied_at"`
+	Checksum  string `json:"checksum"`
+	// Modified 已执行的sql迁移内容被修改
+	Modified bool `json:"modified"`
+	// Missing 已执行的迁移不存在
+	Missing bool `json:"missing"`
+	// Dirty sql迁移执行中或执行失败 需要手动修复数据库后处理迁移记录
+	Dirty bool `json:"dirty"`
+}
+
+// DbMigratorOpt 迁移选项
+type DbMigratorOpt struct {
+	// Table 迁移记录表名 为空时为 DbMigrationTable
+	Table string
+	// LockTimeout 等待其他实例执行迁移的超时 为0时为1分钟
+	LockTimeout time.Duration
+}
+
+// DbMigrator 版本迁移 多个实例同时执行时使用 GET_LOCK 互斥
+type DbMigrator struct {
+	db         *sqlx.DB
+	dir        string
+	opt        DbMigratorOpt
+	migrations []DbMigration
+}
+
+// dbMigrationRecord 迁移记录
+type dbMigrationRecord struct {
+	Version   int64  `db:"version"`
+	Name      string `db:"name"`
+	Checksum  string `db:"checksum"`
+	AppliedAt int64  `db:"applied_at"`
+	Dirty     int64  `db:"dirty"`
+}
+
+// DbMigratorCreate 创建迁移 dir 中为 版本_名字.up.sql 和 版本_名字.down.sql 为空时只使用 Add 添加的迁移
+func DbMigratorCreate(db *sqlx.DB, dir string, opt *DbMigratorOpt) *DbMigrator {
+	m := &DbMigrator{
+		db:  db,
+		dir: dir,
+	}
+	if opt != nil {
+		m.opt = *opt
+	}
+	if m.opt.Table == "" {
+		m.opt.Table = DbMigrationTable
+	}
+	if m.opt.LockTimeout <= 0 {
+		m.opt.LockTimeout = time.Minute
+	}
+	return m
+}
+
+// Add 添加迁移 用于go函数迁移
+func (m *DbMigrator) Add(migration DbMigration) {
+	m.migrations = append(m.migrations, migration)
+}
+
+// Up 执行所有未执行的迁移 返回执行的个数
+// 已执行的sql迁移内容被修改或有dirty的迁移时返回错误
+func (m *DbMigrator) Up(ctx context.Context) (int, error) {
+	migrations, err := m.load()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = DbWithLock(ctx, m.db, m.lockName(), m.opt.LockTimeout, func(ctx context.Context, conn DbExeAble) error {
+		records, err := m.records(ctx, conn)
+		if err != nil {
+			return err
+		}
+		err = m.checkDirty(records)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			record, ok := records[migration.Version]
+			if ok {
+				if record.Checksum != dbMigrationChecksum(migration) {
+					return fmt.Errorf("migration %d checksum error", migration.Version)
+				}
+				continue
+			}
+			err = m.apply(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down 回滚最后执行的n个迁移 返回回滚的个数 有dirty的迁移时返回错误
+func (m *DbMigrator) Down(ctx context.Context, n int) (int, error) {
+	migrations, err := m.load()
+	if err != nil {
+		return 0, err
+	}
+	migrationMap := map[int64]DbMigration{}
+	for _, migration := range migrations {
+		migrationMap[migration.Version] = migration
+	}
+	count := 0
+	err = DbWithLock(ctx, m.db, m.lockName(), m.opt.LockTimeout, func(ctx context.Context, conn DbExeAble) error {
+		records, err := m.records(ctx, conn)
+		if err != nil {
+			return err
+		}
+		err = m.checkDirty(records)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(records))
+		for version := range records {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i] > versions[j]
+		})
+		for _, version := range versions {
+			if count >= n {
+				break
+			}
+			migration, ok := migrationMap[version]
+			if !ok {
+				return fmt.Errorf("migration %d miss", version)
+			}
+			if migration.Down == nil && strings.TrimSpace(migration.DownSQL) == "" {
+				return fmt.Errorf("migration %d down miss", version)
+			}
+			err = m.apply(ctx, conn, migration, false)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Status 获取所有迁移的状态 按版本排序
+func (m *DbMigrator) Status(ctx context.Context) ([]*DbMigrationStatus, error) {
+	migrations, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.records(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []*DbMigrationStatus
+	for _, migration := range migrations {
+		status := &DbMigrationStatus{
+			Version:  migration.Version,
+			Name:     migration.Name,
+			Checksum: dbMigrationChecksum(migration),
+		}
+		record, ok := records[migration.Version]
+		if ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			status.Modified = record.Checksum != status.Checksum
+			status.Dirty = record.Dirty != 0
+			delete(records, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range records {
+		statuses = append(statuses, &DbMigrationStatus{
+			Version:   record.Version,
+			Name:      record.Name,
+			Applied:   true,
+			AppliedAt: record.AppliedAt,
+			Checksum:  record.Checksum,
+			Missing:   true,
+			Dirty:     record.Dirty != 0,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Baseline 为已有数据库生成第一个迁移 内容为 DbStructGetDiff 的结果 返回生成的文件路径
+// 数据库与sql文件一致时生成的迁移只有 BEGIN SET FOREIGN_KEY_CHECKS COMMIT 执行 Up 后即完成基线
+func (m *DbMigrator) Baseline(ctx context.Context, tableNames []string, sqlFilePath string) (string, error) {
+	if m.dir == "" {
+		return "", fmt.Errorf("migration dir miss")
+	}
+	migrations, err := m.load()
+	if err != nil {
+		return "", err
+	}
+	if len(migrations) > 0 {
+		return "", fmt.Errorf("migration exists")
+	}
+	diff, err := DbStructGetDiff(m.db, tableNames, sqlFilePath)
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(m.dir, time.Now().Format("20060102150405")+"_baseline.up.sql")
+	err = ioutil.WriteFile(filePath, []byte(diff), 0644)
+	if err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// apply 执行或回滚一个迁移并更新记录
+func (m *DbMigrator) apply(ctx context.Context, conn DbExeAble, migration DbMigration, isUp bool) error {
+	f := migration.Down
+	content := migration.DownSQL
+	if isUp {
+		f = migration.Up
+		content = migration.UpSQL
+	}
+	record := func(ctx context.Context, tx DbExeAble) error {
+		if !isUp {
+			_, err := DbHardDeleteKV(ctx, tx, m.opt.Table, []string{"version"}, []interface{}{migration.Version})
+			return err
+		}
+		_, err := DbInsertKV(ctx, tx, m.opt.Table, H{
+			"version":    migration.Version,
+			"name":       migration.Name,
+			"checksum":   dbMigrationChecksum(migration),
+			"applied_at": TimeGetMillisecond(),
+		})
+		return err
+	}
+	if f != nil {
+		err := DbTransactionContext(ctx, conn, func(ctx context.Context, dbTx DbExeAble) error {
+			err := f(ctx, dbTx)
+			if err != nil {
+				return err
+			}
+			return record(ctx, dbTx)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d error: %w", migration.Version, err)
+		}
+		return nil
+	}
+	// ddl会隐式提交 sql迁移不使用事物 执行前标记为dirty 中途失败时 Up Down 拒绝继续执行
+	if isUp {
+		_, err := DbInsertKV(ctx, conn, m.opt.Table, H{
+			"version":    migration.Version,
+			"name":       migration.Name,
+			"checksum":   dbMigrationChecksum(migration),
+			"applied_at": TimeGetMillisecond(),
+			"dirty":      1,
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		err := m.setDirty(ctx, conn, migration.Version, 1)
+		if err != nil {
+			return err
+		}
+	}
+	for _, stmt := range dbSQLSplit(content) {
+		_, err := dbExec(ctx, conn, stmt, nil)
+		if err != nil {
+			dbConnReset(conn)
+			return fmt.Errorf("migration %d error: %w", migration.Version, err)
+		}
+	}
+	if isUp {
+		return m.setDirty(ctx, conn, migration.Version, 0)
+	}
+	return record(ctx, conn)
+}
+
+// setDirty 更新迁移记录的dirty
+func (m *DbMigrator) setDirty(ctx context.Context, tx DbExeAble, version int64, dirty int64) error {
+	_, err := DbUpdateKV(ctx, tx, m.opt.Table, H{
+		"dirty": dirty,
+	}, []string{"version"}, []interface{}{version})
+	return err
+}
+
+// checkDirty 有dirty的迁移时返回错误
+func (m *DbMigrator) checkDirty(records map[int64]*dbMigrationRecord) error {
+	for _, record := range records {
+		if record.Dirty != 0 {
+			return fmt.Errorf("migration %d dirty, fix the database and the record in %s first", record.Version, m.opt.Table)
+		}
+	}
+	return nil
+}
+
+// records 获取迁移记录 表不存在时创建
+func (m *DbMigrator) records(ctx context.Context, tx DbExeAble) (map[int64]*dbMigrationRecord, error) {
+	_, err := dbExec(
+		ctx,
+		tx,
+		`CREATE TABLE IF NOT EXISTS `+m.opt.Table+` (
+  version bigint(20) NOT NULL,
+  name varchar(255) NOT NULL DEFAULT '',
+  checksum varchar(64) NOT NULL DEFAULT '',
+  applied_at bigint(20) NOT NULL DEFAULT '0',
+  dirty tinyint(4) NOT NULL DEFAULT '0',
+  PRIMARY KEY (version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*dbMigrationRecord
+	err = DbSelectKV(ctx, tx, &rows, m.opt.Table, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	records := map[int64]*dbMigrationRecord{}
+	for _, row := range rows {
+		records[row.Version] = row
+	}
+	return records, nil
+}
+
+// load 读取目录中的迁移并与 Add 添加的迁移合并 按版本排序
+func (m *DbMigrator) load() ([]DbMigration, error) {
+	migrationMap := map[int64]*DbMigration{}
+	if m.dir != "" {
+		files, err := ioutil.ReadDir(m.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, file := range files {
+			matches := dbMigrationFileRe.FindStringSubmatch(file.Name())
+			if file.IsDir() || matches == nil {
+				continue
+			}
+			version, err := strconv.ParseInt(matches[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			content, err := ioutil.ReadFile(filepath.Join(m.dir, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			migration, ok := migrationMap[version]
+			if !ok {
+				migration = &DbMigration{
+					Version: version,
+					Name:    matches[2],
+				}
+				migrationMap[version] = migration
+			} else if migration.Name != matches[2] {
+				return nil, fmt.Errorf("migration %d name error: %s %s", version, migration.Name, matches[2])
+			}
+			if matches[3] == "up" {
+				migration.UpSQL = string(content)
+			} else {
+				migration.DownSQL = string(content)
+			}
+		}
+	}
+	for i := range m.migrations {
+		migration := m.migrations[i]
+		if _, ok := migrationMap[migration.Version]; ok {
+			return nil, fmt.Errorf("migration %d duplicate", migration.Version)
+		}
+		migrationMap[migration.Version] = &migration
+	}
+	migrations := make([]DbMigration, 0, len(migrationMap))
+	for _, migration := range migrationMap {
+		if migration.Up == nil && migration.UpSQL == "" && migration.DownSQL != "" {
+			return nil, fmt.Errorf("migration %d up miss", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// lockName 迁移锁名
+func (m *DbMigrator) lockName() string {
+	return "mcommon_" + m.opt.Table
+}
+
+// dbMigrationChecksum sql迁移为up sql的sha256 go迁移为空
+func dbMigrationChecksum(migration DbMigration) string {
+	if migration.Up != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(migration.UpSQL))
+	return hex.EncodeToString(sum[:])
+}
+
+// dbSQLSplit 按顶层的分号拆分多条sql 去除注释和空语句
+func dbSQLSplit(content string) []string {
+	var stmts []string
+	var stmt strings.Builder
+	var quote byte
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if quote != 0 {
+			stmt.WriteByte(c)
+			if c == '\\' && i+1 < len(content) {
+				i++
+				stmt.WriteByte(content[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '#' || (c == '-' && strings.HasPrefix(content[i:], "-- ")):
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			stmt.WriteByte('\n')
+			continue
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += end + 3
+			}
+			stmt.WriteByte(' ')
+			continue
+		case c == ';':
+			if s := strings.TrimSpace(stmt.String()); s != "" {
+				stmts = append(stmts, s)
+			}
+			stmt.Reset()
+			continue
+		}
+		stmt.WriteByte(c)
+	}
+	if s := strings.TrimSpace(stmt.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
@@ -0,0 +1,81 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDbSQLSplit(t *testing.T) {
+	content := `-- 创建表
+CREATE TABLE t_a (
+  id INT, # 主键
+  name VARCHAR(32) DEFAULT 'a;b' COMMENT "c;d"
+);
+/* 多行
+   注释; */
+INSERT INTO t_a VALUES (1, 'it\'s;');;
+INSERT INTO ` + "`t;b`" + ` VALUES (2, 'x')
+`
+	stmts := dbSQLSplit(content)
+	want := []string{
+		"CREATE TABLE t_a (\n  id INT, \n  name VARCHAR(32) DEFAULT 'a;b' COMMENT \"c;d\"\n)",
+		`INSERT INTO t_a VALUES (1, 'it\'s;')`,
+		"INSERT INTO `t;b` VALUES (2, 'x')",
+	}
+	if !reflect.DeepEqual(stmts, want) {
+		t.Fatalf("got %q", stmts)
+	}
+
+	if stmts := dbSQLSplit(" ; -- x\n"); len(stmts) != 0 {
+		t.Fatalf("got %q", stmts)
+	}
+}
+
+// testMigrateConn 记录执行的语句 遇到 failQuery 时返回错误
+type testMigrateConn struct {
+	DbExeAble
+	queries   []string
+	failQuery string
+}
+
+func (c *testMigrateConn) Rebind(query string) string {
+	return query
+}
+
+func (c *testMigrateConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	c.queries = append(c.queries, query)
+	if query == c.failQuery {
+		return nil, fmt.Errorf("exec error")
+	}
+	return testResult(1), nil
+}
+
+func TestDbMigratorApplyReset(t *testing.T) {
+	m := &DbMigrator{
+		opt: DbMigratorOpt{Table: "t_migration"},
+	}
+	conn := &testMigrateConn{failQuery: "ALTER TABLE t_a ADD COLUMN b INT"}
+	err := m.apply(context.Background(), conn, DbMigration{
+		Version: 1,
+		Name:    "baseline",
+		UpSQL:   "BEGIN;\nSET FOREIGN_KEY_CHECKS = 0;\nALTER TABLE t_a ADD COLUMN b INT;\nSET FOREIGN_KEY_CHECKS = 1;\nCOMMIT;",
+	}, true)
+	if err == nil {
+		t.Fatal("want migration error")
+	}
+	// 失败后回滚并恢复外键检查 不把会话状态带回连接池
+	queries := conn.queries[1:]
+	want := []string{
+		"BEGIN",
+		"SET FOREIGN_KEY_CHECKS = 0",
+		"ALTER TABLE t_a ADD COLUMN b INT",
+		"ROLLBACK",
+		"SET FOREIGN_KEY_CHECKS = 1",
+	}
+	if !reflect.DeepEqual(queries, want) {
+		t.Fatalf("queries: %q", queries)
+	}
+}
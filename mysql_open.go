@@ -0,0 +1,126 @@
+package mcommon
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// dbTLSConfigID 注册tls配置的序号
+var dbTLSConfigID int64
+
+// DbOptions 数据库连接选项
+type DbOptions struct {
+	// DSN 数据源 如 user:password@tcp(127.0.0.1:3306)/db?parseTime=true
+	DSN string
+	// MaxOpenConns 最大连接数 为0时为 NumCPU*20+1
+	MaxOpenConns int
+	// MaxIdleConns 最大空闲连接数 为0时与 MaxOpenConns 相同
+	MaxIdleConns int
+	// ConnMaxLifetime 连接最大存活时间 为0时为1小时
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime 连接最大空闲时间 为0时不限制 需要go1.15
+	ConnMaxIdleTime time.Duration
+	// DialTimeout 建立连接超时 为0时使用DSN中的设置
+	DialTimeout time.Duration
+	// ReadTimeout 读超时 为0时使用DSN中的设置
+	ReadTimeout time.Duration
+	// WriteTimeout 写超时 为0时使用DSN中的设置
+	WriteTimeout time.Duration
+	// TLSConfig tls配置 为nil时使用DSN中的设置
+	TLSConfig *tls.Config
+	// PingRetry 启动时ping失败的重试次数
+	PingRetry int
+	// PingBackoff 首次重试的等待时间 之后每次翻倍 为0时为1秒
+	PingBackoff time.Duration
+	// ShowSQL 是否记录该连接执行的sql 见 DbGetDebugMap
+	ShowSQL bool
+	// Name 不为空时注册到连接池统计 见 DbPoolRegister
+	Name string
+}
+
+// DbOpen 创建数据库连接 连接或ping失败时返回错误
+func DbOpen(ctx context.Context, opts DbOptions) (*sqlx.DB, error) {
+	cfg, err := mysql.ParseDSN(opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DialTimeout > 0 {
+		cfg.Timeout = opts.DialTimeout
+	}
+	if opts.ReadTimeout > 0 {
+		cfg.ReadTimeout = opts.ReadTimeout
+	}
+	if opts.WriteTimeout > 0 {
+		cfg.WriteTimeout = opts.WriteTimeout
+	}
+	if opts.TLSConfig != nil {
+		name := fmt.Sprintf("mcommon_%d", atomic.AddInt64(&dbTLSConfigID, 1))
+		err = mysql.RegisterTLSConfig(name, opts.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLSConfig = name
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+
+	count := opts.MaxOpenConns
+	if count <= 0 {
+		count = runtime.NumCPU()*20 + 1
+	}
+	idle := opts.MaxIdleConns
+	if idle <= 0 {
+		idle = count
+	}
+	lifetime := opts.ConnMaxLifetime
+	if lifetime <= 0 {
+		lifetime = 1 * time.Hour
+	}
+	db.SetMaxOpenConns(count)
+	db.SetMaxIdleConns(idle)
+	db.SetConnMaxLifetime(lifetime)
+	if opts.ConnMaxIdleTime > 0 {
+		dbSetConnMaxIdleTime(db.DB, opts.ConnMaxIdleTime)
+	}
+
+	backoff := opts.PingBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			break
+		}
+		if attempt >= opts.PingRetry {
+			_ = db.Close()
+			return nil, err
+		}
+		Log.Warnf("db ping retry %d/%d after %s: %s", attempt+1, opts.PingRetry, backoff, err.Error())
+		select {
+		case <-ctx.Done():
+			_ = db.Close()
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	if opts.ShowSQL {
+		DbAddQueryHook(db, dbDebugHook)
+	}
+	if opts.Name != "" {
+		DbPoolRegister(opts.Name, db)
+	}
+	return db, nil
+}
@@ -0,0 +1,319 @@
+package mcommon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// DbOutboxTable 发件箱表名 表结构见 sql/outbox.sql
+const DbOutboxTable = "t_outbox"
+
+const (
+	// DbOutboxStatusPending 未发送
+	DbOutboxStatusPending = 0
+	// DbOutboxStatusSent 已发送
+	DbOutboxStatusSent = 1
+	// DbOutboxStatusFailed 超过最大重试次数
+	DbOutboxStatusFailed = 2
+)
+
+// DbOutboxMessage 发件箱消息
+type DbOutboxMessage struct {
+	ID         int64  `db:"id" json:"id"`
+	Topic      string `db:"topic" json:"topic"`
+	Payload    string `db:"payload" json:"payload"`
+	Status     int64  `db:"status" json:"status"`
+	RetryCount int64  `db:"retry_count" json:"retry_count"`
+	NextAt     int64  `db:"next_at" json:"next_at"`
+	LastError  string `db:"last_error" json:"last_error"`
+	CreatedAt  int64  `db:"created_at" json:"created_at"`
+	SentAt     int64  `db:"sent_at" json:"sent_at"`
+}
+
+// DbOutboxPublisher 消息发布者
+type DbOutboxPublisher interface {
+	Publish(ctx context.Context, msg *DbOutboxMessage) error
+}
+
+// DbOutboxPublisherFunc 函数形式的发布者
+type DbOutboxPublisherFunc func(ctx context.Context, msg *DbOutboxMessage) error
+
+// Publish 发布
+func (f DbOutboxPublisherFunc) Publish(ctx context.Context, msg *DbOutboxMessage) error {
+	return f(ctx, msg)
+}
+
+// DbOutboxAdd 在tx中写入发件箱 tx提交后消息才会被发送
+// payload 为 string 或 []byte 时直接保存 其他类型保存为json
+func DbOutboxAdd(ctx context.Context, tx DbExeAble, topic string, payload interface{}) (int64, error) {
+	var content string
+	switch v := payload.(type) {
+	case string:
+		content = v
+	case []byte:
+		content = string(v)
+	default:
+		bs, err := json.Marshal(payload)
+		if err != nil {
+			return 0, err
+		}
+		content = string(bs)
+	}
+	now := TimeGetMillisecond()
+	return DbInsertKV(
+		ctx,
+		tx,
+		DbOutboxTable,
+		H{
+			"topic":      topic,
+			"payload":    content,
+			"status":     DbOutboxStatusPending,
+			"next_at":    now,
+			"created_at": now,
+		},
+	)
+}
+
+// DbOutboxRelayOpt 投递选项
+type DbOutboxRelayOpt struct {
+	// BatchSize 每次领取的消息数 为0时为100
+	BatchSize int64
+	// Interval 没有消息时的轮询间隔 为0时为1秒
+	Interval time.Duration
+	// MaxRetry 最大重试次数 超过后标记为 DbOutboxStatusFailed 为0时为10
+	MaxRetry int64
+	// MinBackoff 首次重试的等待时间 之后每次翻倍 为0时为1秒
+	MinBackoff time.Duration
+	// MaxBackoff 最大重试等待时间 为0时为10分钟
+	MaxBackoff time.Duration
+	// LeaseTime 领取后的租约时间 需要大于一批消息的发布耗时 为0时为1分钟
+	LeaseTime time.Duration
+}
+
+// DbOutboxRelay 发件箱投递 多个实例可同时运行
+type DbOutboxRelay struct {
+	db        DbExeAble
+	publisher DbOutboxPublisher
+	opt       DbOutboxRelayOpt
+}
+
+// DbOutboxRelayCreate 创建投递
+func DbOutboxRelayCreate(db DbExeAble, publisher DbOutboxPublisher, opt *DbOutboxRelayOpt) *DbOutboxRelay {
+	r := &DbOutboxRelay{
+		db:        db,
+		publisher: publisher,
+	}
+	if opt != nil {
+		r.opt = *opt
+	}
+	if r.opt.BatchSize <= 0 {
+		r.opt.BatchSize = 100
+	}
+	if r.opt.Interval <= 0 {
+		r.opt.Interval = time.Second
+	}
+	if r.opt.MaxRetry <= 0 {
+		r.opt.MaxRetry = 10
+	}
+	if r.opt.MinBackoff <= 0 {
+		r.opt.MinBackoff = time.Second
+	}
+	if r.opt.MaxBackoff <= 0 {
+		r.opt.MaxBackoff = 10 * time.Minute
+	}
+	if r.opt.LeaseTime <= 0 {
+		r.opt.LeaseTime = time.Minute
+	}
+	return r
+}
+
+// Run 循环投递 直到ctx取消
+func (r *DbOutboxRelay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			Log.Warnf("db outbox relay error: %s", err.Error())
+		}
+		if err == nil && n >= r.opt.BatchSize {
+			// 可能还有未发送的消息 立即继续
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.opt.Interval):
+		}
+	}
+}
+
+// RelayOnce 领取一批到期的消息并投递 返回领取的消息数
+// 使用 FOR UPDATE SKIP LOCKED 领取并把 next_at 推迟 LeaseTime 后提交 之后在事物外发布
+// 发布后更新状态前进程退出时 租约到期后会重新投递 消费方需要按id去重
+func (r *DbOutboxRelay) RelayOnce(ctx context.Context) (int64, error) {
+	rows, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i := range rows {
+		err = r.deliver(ctx, &rows[i])
+		if err != nil {
+			return int64(len(rows)), err
+		}
+	}
+	return int64(len(rows)), nil
+}
+
+// claim 领取一批到期的消息 租约期间其他实例不会领取
+func (r *DbOutboxRelay) claim(ctx context.Context) ([]DbOutboxMessage, error) {
+	var rows []DbOutboxMessage
+	err := DbTransactionContext(ctx, r.db, func(ctx context.Context, dbTx DbExeAble) error {
+		now := TimeGetMillisecond()
+		err := DbSelectKV(
+			ctx,
+			dbTx,
+			&rows,
+			DbOutboxTable,
+			[]string{"status", "next_at"},
+			[]interface{}{DbOutboxStatusPending, DbLte(now)},
+			&DbSelectOpt{
+				OrderBy: []string{"id ASC"},
+				Limit:   r.opt.BatchSize,
+				Lock:    DbLockSkipLocked,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]int64, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		_, err = DbUpdateKV(
+			ctx,
+			dbTx,
+			DbOutboxTable,
+			H{
+				"next_at": now + r.opt.LeaseTime.Milliseconds(),
+			},
+			[]string{"id"},
+			[]interface{}{ids},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// deliver 在事物外投递一条消息并更新状态
+func (r *DbOutboxRelay) deliver(ctx context.Context, msg *DbOutboxMessage) error {
+	updateMap := H{}
+	pubErr := r.publisher.Publish(ctx, msg)
+	now := TimeGetMillisecond()
+	if pubErr == nil {
+		updateMap["status"] = DbOutboxStatusSent
+		updateMap["sent_at"] = now
+		updateMap["last_error"] = ""
+	} else {
+		Log.Warnf("db outbox publish %d error: %s", msg.ID, pubErr.Error())
+		retryCount := msg.RetryCount + 1
+		updateMap["retry_count"] = retryCount
+		updateMap["last_error"] = dbOutboxTruncate(pubErr.Error(), 512)
+		if retryCount >= r.opt.MaxRetry {
+			updateMap["status"] = DbOutboxStatusFailed
+		} else {
+			updateMap["next_at"] = now + r.backoff(retryCount).Milliseconds()
+		}
+	}
+	_, err := DbUpdateKV(
+		ctx,
+		r.db,
+		DbOutboxTable,
+		updateMap,
+		[]string{"id", "status"},
+		[]interface{}{msg.ID, DbOutboxStatusPending},
+	)
+	return err
+}
+
+// dbOutboxTruncate 按字符截断 last_error 列为 varchar(512)
+func dbOutboxTruncate(s string, n int) string {
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
+
+// backoff 第n次重试的等待时间
+func (r *DbOutboxRelay) backoff(n int64) time.Duration {
+	wait := r.opt.MinBackoff
+	for i := int64(1); i < n && wait < r.opt.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > r.opt.MaxBackoff {
+		wait = r.opt.MaxBackoff
+	}
+	return wait
+}
+
+// DbOutboxRedisListPublisher 发布到redis列表 key为主题 会添加基础key前缀
+func DbOutboxRedisListPublisher(client *redis.Client) DbOutboxPublisher {
+	return DbOutboxPublisherFunc(func(ctx context.Context, msg *DbOutboxMessage) error {
+		return client.WithContext(ctx).RPush(redisKey(msg.Topic), msg.Payload).Err()
+	})
+}
+
+// DbOutboxRedisPubSubPublisher 发布到redis频道 频道为主题 会添加基础key前缀
+func DbOutboxRedisPubSubPublisher(client *redis.Client) DbOutboxPublisher {
+	return DbOutboxPublisherFunc(func(ctx context.Context, msg *DbOutboxMessage) error {
+		return client.WithContext(ctx).Publish(redisKey(msg.Topic), msg.Payload).Err()
+	})
+}
+
+// DbOutboxHTTPPublisher POST到webhook 内容为payload
+// 请求头 X-Outbox-Topic X-Outbox-Id 非2xx响应视为失败 client为nil时使用10秒超时
+func DbOutboxHTTPPublisher(url string, client *http.Client) DbOutboxPublisher {
+	if client == nil {
+		client = &http.Client{
+			Timeout: 10 * time.Second,
+		}
+	}
+	return DbOutboxPublisherFunc(func(ctx context.Context, msg *DbOutboxMessage) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(msg.Payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Outbox-Topic", msg.Topic)
+		req.Header.Set("X-Outbox-Id", strconv.FormatInt(msg.ID, 10))
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("outbox webhook status error: %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,280 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DbPageSize 默认每页数量
+const DbPageSize = 20
+
+// DbPage 分页结果
+type DbPage struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	HasMore    bool        `json:"has_more"`
+	NextCursor interface{} `json:"next_cursor"`
+}
+
+// DbPageOpt 分页选项
+type DbPageOpt struct {
+	// Page 页码 从1开始
+	Page int64
+	// Size 每页数量 为0时为 DbPageSize
+	Size int64
+	// CursorColumn 游标列 不为空时使用游标分页 查询中不能包含 ORDER BY 和 LIMIT
+	CursorColumn string
+	// Cursor 上一页返回的 NextCursor 为nil时从头开始
+	Cursor interface{}
+	// Desc 游标分页是否倒序
+	Desc bool
+	// NoCount 不查询总数
+	NoCount bool
+}
+
+// GinH 转换为返回数据
+func (p *DbPage) GinH() gin.H {
+	return gin.H{
+		"items":       p.Items,
+		"total":       p.Total,
+		"has_more":    p.HasMore,
+		"next_cursor": p.NextCursor,
+	}
+}
+
+// DbSelectPage 分页查询 dest 为结构体数组指针
+// 页码模式下 NextCursor 为下一页页码 游标模式下为最后一行的游标列的值
+func DbSelectPage(ctx context.Context, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}, opt *DbPageOpt) (*DbPage, error) {
+	pageOpt := DbPageOpt{}
+	if opt != nil {
+		pageOpt = *opt
+	}
+	if pageOpt.Size <= 0 {
+		pageOpt.Size = DbPageSize
+	}
+	if pageOpt.Page <= 0 {
+		pageOpt.Page = 1
+	}
+	query = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+
+	page := &DbPage{
+		Items: dest,
+	}
+	if !pageOpt.NoCount {
+		countQuery, err := dbPageCountSQL(query)
+		if err != nil {
+			return nil, err
+		}
+		_, err = DbGetNamedContent(
+			ctx,
+			tx,
+			&page.Total,
+			countQuery,
+			argMap,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pageOpt.CursorColumn != "" {
+		err := dbSelectPageCursor(ctx, tx, dest, query, argMap, &pageOpt, page)
+		if err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+
+	offset := (pageOpt.Page - 1) * pageOpt.Size
+	dataQuery := query + "\nLIMIT " + strconv.FormatInt(pageOpt.Size+1, 10) + "\nOFFSET " + strconv.FormatInt(offset, 10)
+	err := DbSelectNamedContent(
+		ctx,
+		tx,
+		dest,
+		dataQuery,
+		argMap,
+	)
+	if err != nil {
+		return nil, err
+	}
+	page.HasMore, err = dbPageTrim(dest, pageOpt.Size)
+	if err != nil {
+		return nil, err
+	}
+	if page.HasMore {
+		page.NextCursor = pageOpt.Page + 1
+	}
+	return page, nil
+}
+
+// dbSelectPageCursor 游标分页查询
+func dbSelectPageCursor(ctx context.Context, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}, opt *DbPageOpt, page *DbPage) error {
+	if dbSQLKeywordIndex(query, "ORDER BY") >= 0 || dbSQLKeywordIndex(query, "LIMIT") >= 0 {
+		return fmt.Errorf("cursor query can not contain ORDER BY or LIMIT")
+	}
+	dataArgMap := map[string]interface{}{}
+	for k, v := range argMap {
+		dataArgMap[k] = v
+	}
+	order := "ASC"
+	op := ">"
+	if opt.Desc {
+		order = "DESC"
+		op = "<"
+	}
+	dataQuery := query
+	if opt.Cursor != nil {
+		cond := opt.CursorColumn + op + ":_page_cursor"
+		dataArgMap["_page_cursor"] = opt.Cursor
+		dataQuery = dbSQLAddWhere(dataQuery, cond)
+	}
+	dataQuery += "\nORDER BY " + opt.CursorColumn + " " + order + "\nLIMIT " + strconv.FormatInt(opt.Size+1, 10)
+	err := DbSelectNamedContent(
+		ctx,
+		tx,
+		dest,
+		dataQuery,
+		dataArgMap,
+	)
+	if err != nil {
+		return err
+	}
+	page.HasMore, err = dbPageTrim(dest, opt.Size)
+	if err != nil {
+		return err
+	}
+	if page.HasMore {
+		rv := reflect.Indirect(reflect.ValueOf(dest))
+		column := opt.CursorColumn
+		if i := strings.LastIndex(column, "."); i >= 0 {
+			column = column[i+1:]
+		}
+		page.NextCursor, err = dbStructValue(rv.Index(rv.Len()-1), column)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbPageTrim 去掉多查询的一行 返回是否还有更多
+func dbPageTrim(dest interface{}, size int64) (bool, error) {
+	rv := reflect.Indirect(reflect.ValueOf(dest))
+	if rv.Kind() != reflect.Slice {
+		return false, fmt.Errorf("dest type error: %T", dest)
+	}
+	if int64(rv.Len()) <= size {
+		return false, nil
+	}
+	rv.Set(rv.Slice(0, int(size)))
+	return true, nil
+}
+
+// dbPageCountSQL 生成统计总数的sql
+// 简单查询替换查询列 包含 DISTINCT GROUP BY UNION 等时使用子查询
+func dbPageCountSQL(query string) (string, error) {
+	selectIndex := dbSQLKeywordIndex(query, "SELECT")
+	fromIndex := dbSQLKeywordIndex(query, "FROM")
+	if selectIndex != 0 || fromIndex < 0 {
+		return "", fmt.Errorf("page query error: %s", query)
+	}
+	if orderIndex := dbSQLKeywordIndex(query, "ORDER BY"); orderIndex >= 0 {
+		query = query[:orderIndex]
+	}
+	for _, keyword := range []string{"DISTINCT", "GROUP BY", "HAVING", "UNION", "LIMIT"} {
+		if dbSQLKeywordIndex(query, keyword) >= 0 {
+			return "SELECT COUNT(*) FROM (\n" + query + "\n) AS _page_count", nil
+		}
+	}
+	return "SELECT COUNT(*)\n" + query[fromIndex:], nil
+}
+
+// dbSQLAddWhere 在顶层的 WHERE 中添加条件 没有 WHERE 时新增
+func dbSQLAddWhere(query string, cond string) string {
+	whereIndex := dbSQLKeywordIndex(query, "WHERE")
+	if whereIndex >= 0 {
+		end := len(query)
+		for _, keyword := range []string{"GROUP BY", "HAVING", "ORDER BY", "LIMIT"} {
+			i := dbSQLKeywordIndex(query, keyword)
+			if i >= 0 && i < end {
+				end = i
+			}
+		}
+		start := whereIndex + len("WHERE")
+		return query[:start] + " (" + query[start:end] + ") AND " + cond + "\n" + query[end:]
+	}
+	end := len(query)
+	for _, keyword := range []string{"GROUP BY", "HAVING"} {
+		i := dbSQLKeywordIndex(query, keyword)
+		if i >= 0 && i < end {
+			end = i
+		}
+	}
+	return query[:end] + "\nWHERE " + cond + "\n" + query[end:]
+}
+
+// dbSQLKeywordIndex 获取顶层(不在括号和引号中)关键字的位置 不存在时返回-1
+// keyword 中的空格可以匹配任意空白
+func dbSQLKeywordIndex(query string, keyword string) int {
+	words := strings.Fields(strings.ToUpper(keyword))
+	upper := strings.ToUpper(query)
+	depth := 0
+	var quote byte
+	for i := 0; i < len(upper); i++ {
+		c := upper[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+			continue
+		case '(':
+			depth++
+			continue
+		case ')':
+			depth--
+			continue
+		}
+		if depth != 0 || (i > 0 && dbSQLIsWordChar(upper[i-1])) {
+			continue
+		}
+		j := i
+		matched := true
+		for k, word := range words {
+			if k > 0 {
+				start := j
+				for j < len(upper) && (upper[j] == ' ' || upper[j] == '\t' || upper[j] == '\n' || upper[j] == '\r') {
+					j++
+				}
+				if j == start {
+					matched = false
+					break
+				}
+			}
+			if !strings.HasPrefix(upper[j:], word) {
+				matched = false
+				break
+			}
+			j += len(word)
+		}
+		if matched && (j == len(upper) || !dbSQLIsWordChar(upper[j])) {
+			return i
+		}
+	}
+	return -1
+}
+
+// dbSQLIsWordChar 是否为标识符字符
+func dbSQLIsWordChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
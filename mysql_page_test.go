@@ -0,0 +1,56 @@
+package mcommon
+
+import (
+	"testing"
+)
+
+func TestDbPageCountSQL(t *testing.T) {
+	cases := []struct {
+		query string
+		count string
+	}{
+		{
+			"SELECT id, name FROM t_user WHERE status=:status ORDER BY id DESC",
+			"SELECT COUNT(*)\nFROM t_user WHERE status=:status ",
+		},
+		{
+			"SELECT id, (SELECT COUNT(*) FROM t_order WHERE t_order.uid=t_user.id) AS c\nFROM t_user",
+			"SELECT COUNT(*)\nFROM t_user",
+		},
+		{
+			"SELECT id FROM t_user WHERE name='ORDER BY x'",
+			"SELECT COUNT(*)\nFROM t_user WHERE name='ORDER BY x'",
+		},
+		{
+			"SELECT DISTINCT uid FROM t_order ORDER BY uid",
+			"SELECT COUNT(*) FROM (\nSELECT DISTINCT uid FROM t_order \n) AS _page_count",
+		},
+		{
+			"SELECT uid, COUNT(*) FROM t_order GROUP BY uid",
+			"SELECT COUNT(*) FROM (\nSELECT uid, COUNT(*) FROM t_order GROUP BY uid\n) AS _page_count",
+		},
+		{
+			"SELECT id FROM a UNION SELECT id FROM b",
+			"SELECT COUNT(*) FROM (\nSELECT id FROM a UNION SELECT id FROM b\n) AS _page_count",
+		},
+	}
+	for _, c := range cases {
+		count, err := dbPageCountSQL(c.query)
+		if err != nil {
+			t.Fatalf("%s: %s", c.query, err)
+		}
+		if count != c.count {
+			t.Errorf("%s:\ngot  %q\nwant %q", c.query, count, c.count)
+		}
+	}
+
+	for _, query := range []string{
+		"UPDATE t_user SET a=1",
+		"SELECT 1",
+	} {
+		_, err := dbPageCountSQL(query)
+		if err == nil {
+			t.Errorf("%s: want error", query)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package mcommon
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrStaleVersion 乐观锁版本不匹配 更新时没有匹配的行
+var ErrStaleVersion = errors.New("stale version")
+
+// DbTablePolicy 表策略 由 KV 函数自动应用
+type DbTablePolicy struct {
+	// VersionColumn 乐观锁版本列 更新时自动加1
+	// 更新条件中包含该列时作为期望版本检查 不匹配时返回 ErrStaleVersion
+	VersionColumn string
+	// DeletedColumn 软删除时间列 删除时设置为 NOW()
+	// 查询和更新时自动过滤已删除的行 条件中包含该列时不过滤
+	DeletedColumn string
+	// Audit 是否记录审计日志 DbUpdateKV DbDeleteKV 时把修改前后的行写入 DbAuditTable
+	Audit bool
+	// PrimaryKey 主键列 用于审计日志 为空时为 id
+	PrimaryKey string
+}
+
+// dbPolicies 各表的策略
+var dbPolicies = struct {
+	sync.RWMutex
+	m map[string]DbTablePolicy
+}{
+	m: map[string]DbTablePolicy{},
+}
+
+// DbSetTablePolicy 设置表策略
+func DbSetTablePolicy(table string, policy DbTablePolicy) {
+	dbPolicies.Lock()
+	defer dbPolicies.Unlock()
+	dbPolicies.m[table] = policy
+}
+
+// DbGetTablePolicy 获取表策略 分片的物理表未设置时使用逻辑表的策略
+func DbGetTablePolicy(table string) DbTablePolicy {
+	dbPolicies.RLock()
+	policy, ok := dbPolicies.m[table]
+	dbPolicies.RUnlock()
+	if ok {
+		return policy
+	}
+	logical, ok := dbShardLogical(table)
+	if !ok {
+		return policy
+	}
+	dbPolicies.RLock()
+	defer dbPolicies.RUnlock()
+	return dbPolicies.m[logical]
+}
+
+// dbPolicyWhere 添加未删除的条件
+func dbPolicyWhere(policy DbTablePolicy, keys []string, values []interface{}) ([]string, []interface{}) {
+	if policy.DeletedColumn == "" || IsStringInSlice(keys, policy.DeletedColumn) {
+		return keys, values
+	}
+	keys = append(append([]string{}, keys...), policy.DeletedColumn)
+	values = append(append([]interface{}{}, values...), DbIsNull())
+	return keys, values
+}
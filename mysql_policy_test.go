@@ -0,0 +1,87 @@
+package mcommon_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/moremorefun/mcommon"
+	"github.com/moremorefun/mcommon/dbtest"
+)
+
+func TestDbUpdateKVStaleVersion(t *testing.T) {
+	mcommon.DbSetTablePolicy("t_policy_version", mcommon.DbTablePolicy{
+		VersionColumn: "version",
+	})
+	defer mcommon.DbSetTablePolicy("t_policy_version", mcommon.DbTablePolicy{})
+
+	db := dbtest.New()
+	db.Stub(`^UPDATE`).WillReturnResult(0, 0)
+
+	_, err := mcommon.DbUpdateKV(
+		context.Background(),
+		db,
+		"t_policy_version",
+		mcommon.H{"name": "a"},
+		[]string{"id", "version"},
+		[]interface{}{1, 3},
+	)
+	if err != mcommon.ErrStaleVersion {
+		t.Fatalf("err: %v", err)
+	}
+	calls := db.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("calls: %v", calls)
+	}
+	if !regexp.MustCompile(`version=version\+1`).MatchString(calls[0].Query) ||
+		!regexp.MustCompile(`AND version=\?`).MatchString(calls[0].Query) {
+		t.Fatalf("query: %s", calls[0].Query)
+	}
+
+	// 条件中没有版本列时不检查
+	db.Reset()
+	db.Stub(`^UPDATE`).WillReturnResult(0, 0)
+	count, err := mcommon.DbUpdateKV(
+		context.Background(),
+		db,
+		"t_policy_version",
+		mcommon.H{"name": "a"},
+		[]string{"id"},
+		[]interface{}{1},
+	)
+	if err != nil || count != 0 {
+		t.Fatalf("count: %d err: %v", count, err)
+	}
+}
+
+func TestDbDeleteKVSoft(t *testing.T) {
+	mcommon.DbSetTablePolicy("t_policy_deleted", mcommon.DbTablePolicy{
+		DeletedColumn: "deleted_at",
+	})
+	defer mcommon.DbSetTablePolicy("t_policy_deleted", mcommon.DbTablePolicy{})
+
+	db := dbtest.New()
+	db.Stub(`^UPDATE`).WillReturnResult(0, 1)
+
+	count, err := mcommon.DbDeleteKV(
+		context.Background(),
+		db,
+		"t_policy_deleted",
+		[]string{"id"},
+		[]interface{}{1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("count: %d", count)
+	}
+	calls := db.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("calls: %v", calls)
+	}
+	re := regexp.MustCompile(`^UPDATE\s+t_policy_deleted\s+SET\s+deleted_at=NOW\(\)\s+WHERE\s+id=\?\s+AND deleted_at IS NULL\s*$`)
+	if !re.MatchString(calls[0].Query) {
+		t.Fatalf("query: %q", calls[0].Query)
+	}
+}
@@ -0,0 +1,203 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// DbMetricsMaxQueries DbMetricsText 输出的语句数上限 避免prometheus序列过多
+const DbMetricsMaxQueries = 200
+
+// dbPoolWaitThreshold 两次采样间等待时间增长的告警阈值 纳秒
+var dbPoolWaitThreshold int64
+
+// dbPools 注册的连接池
+var dbPools = struct {
+	sync.RWMutex
+	m        map[string]*sqlx.DB
+	lastWait map[string]time.Duration
+}{
+	m:        map[string]*sqlx.DB{},
+	lastWait: map[string]time.Duration{},
+}
+
+// DbPoolStat 连接池统计 等待时间单位为毫秒
+type DbPoolStat struct {
+	Name              string  `json:"name"`
+	MaxOpen           int     `json:"max_open"`
+	Open              int     `json:"open"`
+	InUse             int     `json:"in_use"`
+	Idle              int     `json:"idle"`
+	WaitCount         int64   `json:"wait_count"`
+	WaitDuration      float64 `json:"wait_duration"`
+	MaxIdleClosed     int64   `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64   `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64   `json:"max_lifetime_closed"`
+}
+
+// DbPoolRegister 注册连接池 同名时覆盖
+func DbPoolRegister(name string, db *sqlx.DB) {
+	dbPools.Lock()
+	defer dbPools.Unlock()
+	dbPools.m[name] = db
+	delete(dbPools.lastWait, name)
+}
+
+// DbPoolUnregister 取消注册连接池
+func DbPoolUnregister(name string) {
+	dbPools.Lock()
+	defer dbPools.Unlock()
+	delete(dbPools.m, name)
+	delete(dbPools.lastWait, name)
+}
+
+// DbSetPoolWaitThreshold 设置等待时间告警阈值 DbPoolMonitor 两次采样间的等待时间增长超过阈值时打印日志 为0时不打印
+func DbSetPoolWaitThreshold(d time.Duration) {
+	atomic.StoreInt64(&dbPoolWaitThreshold, int64(d))
+}
+
+// DbGetPoolStats 获取所有连接池统计 按名字排序
+func DbGetPoolStats() []DbPoolStat {
+	dbPools.RLock()
+	defer dbPools.RUnlock()
+	stats := make([]DbPoolStat, 0, len(dbPools.m))
+	for name, db := range dbPools.m {
+		s := db.Stats()
+		stats = append(stats, DbPoolStat{
+			Name:              name,
+			MaxOpen:           s.MaxOpenConnections,
+			Open:              s.OpenConnections,
+			InUse:             s.InUse,
+			Idle:              s.Idle,
+			WaitCount:         s.WaitCount,
+			WaitDuration:      float64(s.WaitDuration) / float64(time.Millisecond),
+			MaxIdleClosed:     s.MaxIdleClosed,
+			MaxIdleTimeClosed: dbMaxIdleTimeClosed(s),
+			MaxLifetimeClosed: s.MaxLifetimeClosed,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// DbPoolMonitor 定时采样连接池 等待时间增长超过阈值时打印日志 直到ctx取消
+// interval 为0时为10秒
+func DbPoolMonitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		dbPoolCheck()
+	}
+}
+
+// dbPoolCheck 检查各连接池等待时间的增长
+func dbPoolCheck() {
+	threshold := time.Duration(atomic.LoadInt64(&dbPoolWaitThreshold))
+	dbPools.Lock()
+	defer dbPools.Unlock()
+	for name, db := range dbPools.m {
+		s := db.Stats()
+		last, ok := dbPools.lastWait[name]
+		dbPools.lastWait[name] = s.WaitDuration
+		if !ok || threshold <= 0 {
+			continue
+		}
+		delta := s.WaitDuration - last
+		if delta > threshold {
+			Log.Warnf(
+				"db pool %s wait %s exceeds %s, open: %d/%d in_use: %d wait_count: %d",
+				name,
+				delta,
+				threshold,
+				s.OpenConnections,
+				s.MaxOpenConnections,
+				s.InUse,
+				s.WaitCount,
+			)
+		}
+	}
+}
+
+// GinDbPoolStats 返回连接池统计以及语句执行次数
+func GinDbPoolStats(c *gin.Context) {
+	GinDoRespSuccess(c, gin.H{
+		"pools":   DbGetPoolStats(),
+		"queries": DbGetDebugCountMap(),
+	})
+}
+
+// GinDbMetrics 返回prometheus文本格式的连接池统计和语句统计
+func GinDbMetrics(c *gin.Context) {
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(DbMetricsText()))
+}
+
+// DbMetricsText 生成prometheus文本格式的连接池统计和语句统计
+func DbMetricsText() string {
+	var b strings.Builder
+	pools := DbGetPoolStats()
+	gauges := []struct {
+		name string
+		help string
+		typ  string
+		get  func(s DbPoolStat) float64
+	}{
+		{"mcommon_db_max_open_connections", "Maximum number of open connections.", "gauge", func(s DbPoolStat) float64 { return float64(s.MaxOpen) }},
+		{"mcommon_db_open_connections", "Number of established connections.", "gauge", func(s DbPoolStat) float64 { return float64(s.Open) }},
+		{"mcommon_db_in_use_connections", "Number of connections currently in use.", "gauge", func(s DbPoolStat) float64 { return float64(s.InUse) }},
+		{"mcommon_db_idle_connections", "Number of idle connections.", "gauge", func(s DbPoolStat) float64 { return float64(s.Idle) }},
+		{"mcommon_db_wait_count_total", "Total number of connections waited for.", "counter", func(s DbPoolStat) float64 { return float64(s.WaitCount) }},
+		{"mcommon_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", "counter", func(s DbPoolStat) float64 { return s.WaitDuration / 1000 }},
+		{"mcommon_db_max_idle_closed_total", "Total number of connections closed due to max idle.", "counter", func(s DbPoolStat) float64 { return float64(s.MaxIdleClosed) }},
+		{"mcommon_db_max_idle_time_closed_total", "Total number of connections closed due to max idle time.", "counter", func(s DbPoolStat) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"mcommon_db_max_lifetime_closed_total", "Total number of connections closed due to max lifetime.", "counter", func(s DbPoolStat) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, g.typ)
+		for _, s := range pools {
+			fmt.Fprintf(&b, "%s{db=\"%s\"} %g\n", g.name, dbMetricsLabel(s.Name), g.get(s))
+		}
+	}
+	// 标签使用语句id 对应的语句见 GinDbQueryStats 只输出执行次数最多的语句
+	queries := DbGetQueryStats()
+	sort.SliceStable(queries, func(i, j int) bool {
+		return queries[i].Count > queries[j].Count
+	})
+	if len(queries) > DbMetricsMaxQueries {
+		queries = queries[:DbMetricsMaxQueries]
+	}
+	b.WriteString("# HELP mcommon_db_queries_total Total number of executed queries.\n# TYPE mcommon_db_queries_total counter\n")
+	for _, q := range queries {
+		fmt.Fprintf(&b, "mcommon_db_queries_total{query_id=\"%s\"} %d\n", q.ID, q.Count)
+	}
+	b.WriteString("# HELP mcommon_db_query_errors_total Total number of failed queries.\n# TYPE mcommon_db_query_errors_total counter\n")
+	for _, q := range queries {
+		fmt.Fprintf(&b, "mcommon_db_query_errors_total{query_id=\"%s\"} %d\n", q.ID, q.ErrCount)
+	}
+	return b.String()
+}
+
+// dbMetricsLabel 转义prometheus标签值
+func dbMetricsLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
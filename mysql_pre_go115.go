@@ -0,0 +1,19 @@
+//go:build !go1.15
+// +build !go1.15
+
+package mcommon
+
+import (
+	"database/sql"
+	"time"
+)
+
+// dbSetConnMaxIdleTime go1.15 之前不支持 忽略
+func dbSetConnMaxIdleTime(db *sql.DB, d time.Duration) {
+	Log.Warnf("db conn max idle time %s ignored: need go1.15", d)
+}
+
+// dbMaxIdleTimeClosed go1.15 之前没有该统计 为0
+func dbMaxIdleTimeClosed(s sql.DBStats) int64 {
+	return 0
+}
@@ -0,0 +1,276 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DbShard 分片
+type DbShard struct {
+	// Table 物理表名
+	Table string
+	// Db 分片所在的数据库 为nil时使用调用时传入的tx
+	// 传入的tx为事物时 事物需要由 DbTransaction 在该数据库上开启
+	Db DbExeAble
+}
+
+// DbShardRouter 分片路由 把分片键映射到分片
+type DbShardRouter interface {
+	// Route 获取分片键对应的分片
+	Route(key interface{}) (DbShard, error)
+	// Shards 获取所有分片
+	Shards() []DbShard
+}
+
+// dbShardKeyContextKey context中分片键的key
+type dbShardKeyContextKey struct{}
+
+// dbShardTableRe 命名sql中的逻辑表名 如 {t_msg}
+var dbShardTableRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// dbShards 各逻辑表的路由 以及物理表名对应的逻辑表名
+var dbShards = struct {
+	sync.RWMutex
+	routers map[string]DbShardRouter
+	logical map[string]string
+}{
+	routers: map[string]DbShardRouter{},
+	logical: map[string]string{},
+}
+
+// DbSetShardRouter 设置逻辑表的分片路由
+// 设置后 KV 函数传入逻辑表名 命名sql中使用 {逻辑表名} 即可按 context 中的分片键路由
+// 物理表未设置 DbTablePolicy 时使用逻辑表的策略
+func DbSetShardRouter(table string, router DbShardRouter) {
+	dbShards.Lock()
+	defer dbShards.Unlock()
+	dbShards.routers[table] = router
+	for _, shard := range router.Shards() {
+		dbShards.logical[shard.Table] = table
+	}
+}
+
+// DbGetShardRouter 获取逻辑表的分片路由
+func DbGetShardRouter(table string) (DbShardRouter, bool) {
+	dbShards.RLock()
+	defer dbShards.RUnlock()
+	router, ok := dbShards.routers[table]
+	return router, ok
+}
+
+// DbContextWithShardKey 设置分片键
+func DbContextWithShardKey(ctx context.Context, key interface{}) context.Context {
+	return context.WithValue(ctx, dbShardKeyContextKey{}, key)
+}
+
+// DbShardSelectNamedContent 在逻辑表的所有分片上执行查询并合并结果 用于后台统计
+// query 中使用 {逻辑表名} dest 为数组指针 结果按分片顺序合并
+// tx 为事物时在事物中依次执行 否则并发执行
+func DbShardSelectNamedContent(ctx context.Context, tx DbExeAble, table string, dest interface{}, query string, argMap map[string]interface{}) error {
+	router, ok := DbGetShardRouter(table)
+	if !ok {
+		return fmt.Errorf("shard router miss: %s", table)
+	}
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dest type error: %T", dest)
+	}
+	shards := router.Shards()
+	dbs := make([]DbExeAble, len(shards))
+	for i, shard := range shards {
+		db, err := dbShardDb(tx, shard)
+		if err != nil {
+			return err
+		}
+		dbs[i] = db
+	}
+	results := make([]reflect.Value, len(shards))
+	errs := make([]error, len(shards))
+	selectShard := func(i int) {
+		shardQuery := strings.ReplaceAll(query, "{"+table+"}", shards[i].Table)
+		results[i] = reflect.New(rv.Elem().Type())
+		errs[i] = DbSelectNamedContent(ctx, dbs[i], results[i].Interface(), shardQuery, argMap)
+	}
+	if _, ok := tx.(DbTxAble); ok {
+		// 事物的连接不能并发使用
+		for i := range shards {
+			selectShard(i)
+			if errs[i] != nil {
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		for i := range shards {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				selectShard(i)
+			}(i)
+		}
+		wg.Wait()
+	}
+	merged := rv.Elem()
+	for i := range shards {
+		if errs[i] != nil {
+			return fmt.Errorf("shard %s error: %w", shards[i].Table, errs[i])
+		}
+		merged = reflect.AppendSlice(merged, results[i].Elem())
+	}
+	rv.Elem().Set(merged)
+	return nil
+}
+
+// dbShardTable 逻辑表名转换为物理表名 未设置路由的表原样返回
+func dbShardTable(ctx context.Context, tx DbExeAble, table string) (DbExeAble, string, error) {
+	router, ok := DbGetShardRouter(table)
+	if !ok {
+		return tx, table, nil
+	}
+	shard, err := dbShardRoute(ctx, table, router)
+	if err != nil {
+		return nil, "", err
+	}
+	shardTx, err := dbShardDb(tx, shard)
+	if err != nil {
+		return nil, "", err
+	}
+	return shardTx, shard.Table, nil
+}
+
+// dbShardQuery 替换命名sql中的 {逻辑表名}
+func dbShardQuery(ctx context.Context, tx DbExeAble, query string) (DbExeAble, string, error) {
+	if !strings.Contains(query, "{") {
+		return tx, query, nil
+	}
+	var err error
+	var shardTx DbExeAble
+	query = dbShardTableRe.ReplaceAllStringFunc(query, func(s string) string {
+		table := s[1 : len(s)-1]
+		router, ok := DbGetShardRouter(table)
+		if !ok || err != nil {
+			return s
+		}
+		var shard DbShard
+		shard, err = dbShardRoute(ctx, table, router)
+		if err != nil {
+			return s
+		}
+		var db DbExeAble
+		db, err = dbShardDb(tx, shard)
+		if err != nil {
+			return s
+		}
+		if shardTx != nil && !dbShardSameDb(shardTx, db) {
+			err = fmt.Errorf("shard db mismatch: %s", table)
+			return s
+		}
+		shardTx = db
+		return shard.Table
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if shardTx == nil {
+		return tx, query, nil
+	}
+	return shardTx, query, nil
+}
+
+// dbShardRoute 按context中的分片键路由
+func dbShardRoute(ctx context.Context, table string, router DbShardRouter) (DbShard, error) {
+	key := ctx.Value(dbShardKeyContextKey{})
+	if key == nil {
+		return DbShard{}, fmt.Errorf("shard key miss: %s", table)
+	}
+	return router.Route(key)
+}
+
+// dbShardDb 分片使用的数据库
+// 已在事物中时继续使用事物 事物不属于分片所在的数据库时返回错误
+func dbShardDb(tx DbExeAble, shard DbShard) (DbExeAble, error) {
+	if shard.Db == nil || dbShardSameDb(tx, shard.Db) {
+		return tx, nil
+	}
+	if _, ok := tx.(DbTxAble); !ok {
+		return shard.Db, nil
+	}
+	db, ok := dbTxParent(tx)
+	if !ok || !dbShardSameDb(db, shard.Db) {
+		return nil, fmt.Errorf("shard %s not in tx db", shard.Table)
+	}
+	return tx, nil
+}
+
+// dbShardSameDb 是否为同一个数据库对象
+func dbShardSameDb(a DbExeAble, b DbExeAble) bool {
+	return dbHookKeyAble(a) && dbHookKeyAble(b) && a == b
+}
+
+// dbShardLogical 物理表名对应的逻辑表名
+func dbShardLogical(table string) (string, bool) {
+	dbShards.RLock()
+	defer dbShards.RUnlock()
+	logical, ok := dbShards.logical[table]
+	return logical, ok
+}
+
+// DbShardMod 按分片键取模的路由 物理表名为 逻辑表名_序号 如 t_msg_00 ~ t_msg_63
+type DbShardMod struct {
+	shards []DbShard
+}
+
+// DbShardModCreate 创建取模路由 dbs不为空时分片i使用dbs[i%len(dbs)]
+func DbShardModCreate(table string, n int, dbs []DbExeAble) *DbShardMod {
+	width := len(strconv.Itoa(n - 1))
+	if width < 2 {
+		width = 2
+	}
+	r := &DbShardMod{}
+	for i := 0; i < n; i++ {
+		shard := DbShard{
+			Table: fmt.Sprintf("%s_%0*d", table, width, i),
+		}
+		if len(dbs) > 0 {
+			shard.Db = dbs[i%len(dbs)]
+		}
+		r.shards = append(r.shards, shard)
+	}
+	return r
+}
+
+// Route 整数分片键取模 字符串分片键使用crc32后取模
+func (r *DbShardMod) Route(key interface{}) (DbShard, error) {
+	n := uint64(len(r.shards))
+	if n == 0 {
+		return DbShard{}, fmt.Errorf("shard len error")
+	}
+	var index uint64
+	rv := reflect.ValueOf(key)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v := rv.Int()
+		if v < 0 {
+			return DbShard{}, fmt.Errorf("shard key error: %d", v)
+		}
+		index = uint64(v) % n
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		index = rv.Uint() % n
+	case reflect.String:
+		index = uint64(crc32.ChecksumIEEE([]byte(rv.String()))) % n
+	default:
+		return DbShard{}, fmt.Errorf("shard key type error: %T", key)
+	}
+	return r.shards[index], nil
+}
+
+// Shards 所有分片
+func (r *DbShardMod) Shards() []DbShard {
+	return r.shards
+}
@@ -0,0 +1,81 @@
+package mcommon_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/moremorefun/mcommon"
+	"github.com/moremorefun/mcommon/dbtest"
+)
+
+func TestDbShardRoute(t *testing.T) {
+	dbA := dbtest.New()
+	dbA.Stub(`^UPDATE`).WillReturnResult(0, 1)
+	dbB := dbtest.New()
+	dbB.Stub(`^UPDATE`).WillReturnResult(0, 1)
+	mcommon.DbSetShardRouter("t_shard_msg", mcommon.DbShardModCreate("t_shard_msg", 4, []mcommon.DbExeAble{dbA, dbB}))
+
+	// 5%4=1 分片 t_shard_msg_01 在 dbB
+	ctx := mcommon.DbContextWithShardKey(context.Background(), 5)
+	_, err := mcommon.DbUpdateKV(ctx, dbA, "t_shard_msg", mcommon.H{"n": 1}, []string{"id"}, []interface{}{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbA.Calls()) != 0 {
+		t.Fatalf("a calls: %v", dbA.Calls())
+	}
+	calls := dbB.Calls()
+	if len(calls) != 1 || calls[0].Query != "UPDATE\nt_shard_msg_01\nSET\nn=?\nWHERE\nid=?\n" {
+		t.Fatalf("b calls: %q", calls)
+	}
+}
+
+func TestDbShardRouteInTx(t *testing.T) {
+	dbA := dbtest.New()
+	dbA.Stub(`^UPDATE`).WillReturnResult(0, 1)
+	dbB := dbtest.New()
+	mcommon.DbSetShardRouter("t_shard_tx", mcommon.DbShardModCreate("t_shard_tx", 4, []mcommon.DbExeAble{dbA, dbB}))
+
+	err := mcommon.DbTransactionContext(context.Background(), dbA, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+		// 4%4=0 分片在 dbA 使用事物执行
+		_, err := mcommon.DbUpdateKV(
+			mcommon.DbContextWithShardKey(ctx, 4),
+			dbTx,
+			"t_shard_tx",
+			mcommon.H{"n": 1},
+			[]string{"id"},
+			[]interface{}{1},
+		)
+		if err != nil {
+			return err
+		}
+		// 5%4=1 分片在 dbB 不能在 dbA 的事物中执行
+		_, err = mcommon.DbUpdateKV(
+			mcommon.DbContextWithShardKey(ctx, 5),
+			dbTx,
+			"t_shard_tx",
+			mcommon.H{"n": 1},
+			[]string{"id"},
+			[]interface{}{1},
+		)
+		if err == nil {
+			t.Fatal("want shard not in tx db error")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"BEGIN",
+		"UPDATE\nt_shard_tx_00\nSET\nn=?\nWHERE\nid=?\n",
+		"COMMIT",
+	}
+	if queries := testQueries(dbA); !reflect.DeepEqual(queries, want) {
+		t.Fatalf("a queries: %q", queries)
+	}
+	if len(dbB.Calls()) != 0 {
+		t.Fatalf("b calls: %v", dbB.Calls())
+	}
+}
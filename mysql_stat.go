@@ -0,0 +1,215 @@
+package mcommon
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dbStatSampleSize 每条语句保留的耗时样本数
+const dbStatSampleSize = 1024
+
+// dbSlowThreshold 慢查询阈值 纳秒
+var dbSlowThreshold int64
+
+// dbStatInRe 匹配IN展开后的多个占位符
+var dbStatInRe = regexp.MustCompile(`\?(\s*,\s*\?)+`)
+
+// dbStatSpaceRe 匹配空白
+var dbStatSpaceRe = regexp.MustCompile(`\s+`)
+
+// dbStats 各语句的统计
+var dbStats sync.Map
+
+// DbQueryStat 语句统计 耗时单位为毫秒
+type DbQueryStat struct {
+	// ID 语句的sha1前16位 用作prometheus标签
+	ID       string  `json:"id"`
+	Query    string  `json:"query"`
+	Count    int64   `json:"count"`
+	ErrCount int64   `json:"err_count"`
+	P50      float64 `json:"p50"`
+	P95      float64 `json:"p95"`
+	P99      float64 `json:"p99"`
+	Max      float64 `json:"max"`
+}
+
+// dbQueryStat 单条语句的统计数据
+type dbQueryStat struct {
+	lock     sync.Mutex
+	count    int64
+	errCount int64
+	max      time.Duration
+	samples  []time.Duration
+	next     int
+}
+
+// DbSetSlowThreshold 设置慢查询阈值 超过阈值的语句会打印日志 为0时不打印
+func DbSetSlowThreshold(d time.Duration) {
+	atomic.StoreInt64(&dbSlowThreshold, int64(d))
+}
+
+// DbGetQueryStats 获取语句统计 按p99倒序
+func DbGetQueryStats() []DbQueryStat {
+	var stats []DbQueryStat
+	dbStats.Range(func(key, value interface{}) bool {
+		stats = append(stats, value.(*dbQueryStat).snapshot(key.(string)))
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].P99 > stats[j].P99
+	})
+	return stats
+}
+
+// DbResetQueryStats 清空语句统计
+func DbResetQueryStats() {
+	dbStats.Range(func(key, value interface{}) bool {
+		dbStats.Delete(key)
+		return true
+	})
+}
+
+// GinDbQueryStats 返回语句统计
+func GinDbQueryStats(c *gin.Context) {
+	GinDoRespSuccess(c, gin.H{
+		"stats": DbGetQueryStats(),
+	})
+}
+
+// DbNormalizeSQL 归一化sql 数字和字符串常量替换为? IN展开的占位符合并为一个
+// 如 LIMIT 20 OFFSET 40 归一化为 LIMIT ? OFFSET ?
+func DbNormalizeSQL(query string) string {
+	query = dbStatSpaceRe.ReplaceAllString(query, " ")
+	query = dbStatLiteral(query)
+	query = dbStatInRe.ReplaceAllString(query, "?")
+	return query
+}
+
+// dbStatLiteral 把不在标识符中的数字和字符串常量替换为?
+func dbStatLiteral(query string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '`':
+			// 标识符原样保留
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '\'' || c == '"':
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' {
+					i++
+				} else if query[i] == c {
+					// 连续两个引号为转义
+					if i+1 < len(query) && query[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case c >= '0' && c <= '9' && (i == 0 || !dbSQLIsWordChar(query[i-1])):
+			for i+1 < len(query) && (dbSQLIsWordChar(query[i+1])) {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// dbQueryID 语句id
+func dbQueryID(query string) string {
+	sum := sha1.Sum([]byte(query))
+	return hex.EncodeToString(sum[:8])
+}
+
+// dbRecordQuery 记录语句耗时并打印慢查询
+func dbRecordQuery(query string, args []interface{}, du time.Duration, err error) {
+	threshold := time.Duration(atomic.LoadInt64(&dbSlowThreshold))
+	if threshold > 0 && du >= threshold {
+		Log.Warnf("db slow query %s: %s", du, DbInterpolateSQL(query, args))
+	}
+
+	key := DbNormalizeSQL(query)
+	v, ok := dbStats.Load(key)
+	if !ok {
+		v, _ = dbStats.LoadOrStore(key, &dbQueryStat{})
+	}
+	v.(*dbQueryStat).add(du, err)
+}
+
+// add 添加一次执行记录
+func (s *dbQueryStat) add(du time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.count++
+	if err != nil {
+		s.errCount++
+	}
+	if du > s.max {
+		s.max = du
+	}
+	if len(s.samples) < dbStatSampleSize {
+		s.samples = append(s.samples, du)
+	} else {
+		s.samples[s.next] = du
+		s.next = (s.next + 1) % dbStatSampleSize
+	}
+}
+
+// snapshot 获取统计快照
+func (s *dbQueryStat) snapshot(query string) DbQueryStat {
+	s.lock.Lock()
+	samples := make([]time.Duration, len(s.samples))
+	copy(samples, s.samples)
+	stat := DbQueryStat{
+		ID:       dbQueryID(query),
+		Query:    query,
+		Count:    s.count,
+		ErrCount: s.errCount,
+		Max:      dbStatMs(s.max),
+	}
+	s.lock.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	stat.P50 = dbStatMs(dbStatPercentile(samples, 50))
+	stat.P95 = dbStatMs(dbStatPercentile(samples, 95))
+	stat.P99 = dbStatMs(dbStatPercentile(samples, 99))
+	return stat
+}
+
+// dbStatPercentile 获取已排序样本的百分位
+func dbStatPercentile(samples []time.Duration, p int) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	i := (len(samples)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return samples[i]
+}
+
+// dbStatMs 转换为毫秒
+func dbStatMs(du time.Duration) float64 {
+	return float64(du) / float64(time.Millisecond)
+}
@@ -0,0 +1,105 @@
+package mcommon_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/moremorefun/mcommon"
+	"github.com/moremorefun/mcommon/dbtest"
+)
+
+// testQueries 执行的语句 保存点名称去掉序号
+func testQueries(db *dbtest.DB) []string {
+	re := regexp.MustCompile(`mcommon_sp_\d+`)
+	var queries []string
+	for _, call := range db.Calls() {
+		queries = append(queries, re.ReplaceAllString(call.Query, "mcommon_sp"))
+	}
+	return queries
+}
+
+func TestDbTransactionSavepoint(t *testing.T) {
+	db := dbtest.New()
+	db.Stub(`^UPDATE t_a`).WillReturnResult(0, 1)
+
+	errInner := errors.New("inner")
+	err := mcommon.DbTransactionContext(context.Background(), db, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+		_, err := dbTx.ExecContext(ctx, "UPDATE t_a SET n=1")
+		if err != nil {
+			return err
+		}
+		// context中有事物 嵌套的事物出错只回滚到保存点
+		err = mcommon.DbTransactionContext(ctx, db, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+			_, err := dbTx.ExecContext(ctx, "UPDATE t_a SET n=2")
+			if err != nil {
+				return err
+			}
+			return errInner
+		})
+		if err != errInner {
+			t.Fatalf("inner err: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"BEGIN",
+		"UPDATE t_a SET n=1",
+		"SAVEPOINT mcommon_sp",
+		"UPDATE t_a SET n=2",
+		"ROLLBACK TO SAVEPOINT mcommon_sp",
+		"COMMIT",
+	}
+	if queries := testQueries(db); !reflect.DeepEqual(queries, want) {
+		t.Fatalf("queries: %q", queries)
+	}
+}
+
+func TestDbTransactionOtherDb(t *testing.T) {
+	dbA := dbtest.New()
+	dbA.Stub(`^UPDATE t_a`).WillReturnResult(0, 1)
+	dbB := dbtest.New()
+	dbB.Stub(`^UPDATE t_b`).WillReturnResult(0, 1)
+
+	err := mcommon.DbTransactionContext(context.Background(), dbA, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+		// 其他数据库开启新的事物
+		err := mcommon.DbTransactionContext(ctx, dbB, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+			_, err := dbTx.ExecContext(ctx, "UPDATE t_b SET n=1")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// 同一数据库加入context中的事物
+		return mcommon.DbTransactionContext(ctx, dbA, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+			_, err := dbTx.ExecContext(ctx, "UPDATE t_a SET n=1")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantA := []string{
+		"BEGIN",
+		"SAVEPOINT mcommon_sp",
+		"UPDATE t_a SET n=1",
+		"RELEASE SAVEPOINT mcommon_sp",
+		"COMMIT",
+	}
+	if queries := testQueries(dbA); !reflect.DeepEqual(queries, wantA) {
+		t.Fatalf("a queries: %q", queries)
+	}
+	wantB := []string{
+		"BEGIN",
+		"UPDATE t_b SET n=1",
+		"COMMIT",
+	}
+	if queries := testQueries(dbB); !reflect.DeepEqual(queries, wantB) {
+		t.Fatalf("b queries: %q", queries)
+	}
+}
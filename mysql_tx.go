@@ -0,0 +1,113 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// DbErrLockDeadlock 死锁
+	DbErrLockDeadlock = 1213
+	// DbErrLockWaitTimeout 锁等待超时
+	DbErrLockWaitTimeout = 1205
+)
+
+// DbTxOpt 事物选项
+type DbTxOpt func(conf *dbTxConf)
+
+// dbTxConf 事物配置
+type dbTxConf struct {
+	retry      int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	txOpts     sql.TxOptions
+}
+
+// DbTxWithRetry 死锁或锁等待超时时的最大重试次数
+func DbTxWithRetry(n int) DbTxOpt {
+	return func(conf *dbTxConf) {
+		conf.retry = n
+	}
+}
+
+// DbTxWithBackoff 重试的等待时间范围 实际等待时间随重试次数翻倍并加入随机抖动
+func DbTxWithBackoff(min, max time.Duration) DbTxOpt {
+	return func(conf *dbTxConf) {
+		conf.minBackoff = min
+		conf.maxBackoff = max
+	}
+}
+
+// DbTxWithIsolation 事物隔离级别
+func DbTxWithIsolation(level sql.IsolationLevel) DbTxOpt {
+	return func(conf *dbTxConf) {
+		conf.txOpts.Isolation = level
+	}
+}
+
+// DbTxWithReadOnly 只读事物
+func DbTxWithReadOnly() DbTxOpt {
+	return func(conf *dbTxConf) {
+		conf.txOpts.ReadOnly = true
+	}
+}
+
+// DbIsRetryableErr 是否为可重试的mysql错误
+func DbIsRetryableErr(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	switch mysqlErr.Number {
+	case DbErrLockDeadlock, DbErrLockWaitTimeout:
+		return true
+	}
+	return false
+}
+
+// DbTransactionRetry 执行事物 遇到死锁或锁等待超时时重新执行f
+// 嵌套在已有事物中时不重试 由最外层事物重试
+func DbTransactionRetry(ctx context.Context, db DbExeAble, f func(ctx context.Context, dbTx DbExeAble) error, opts ...DbTxOpt) error {
+	conf := dbTxConf{
+		retry:      3,
+		minBackoff: 20 * time.Millisecond,
+		maxBackoff: time.Second,
+	}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	txOpts := &conf.txOpts
+	if conf.txOpts == (sql.TxOptions{}) {
+		txOpts = nil
+	}
+	_, isTx := DbFromContext(ctx, db).(DbTxAble)
+	backoff := conf.minBackoff
+	for attempt := 0; ; attempt++ {
+		err := dbTransactionOpts(ctx, db, txOpts, f)
+		if err == nil {
+			return nil
+		}
+		if isTx || attempt >= conf.retry || !DbIsRetryableErr(err) {
+			return err
+		}
+		wait := backoff
+		if wait > 0 {
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait)))
+		}
+		Log.Warnf("db transaction retry %d/%d after %s: %s", attempt+1, conf.retry, wait, err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > conf.maxBackoff {
+			backoff = conf.maxBackoff
+		}
+	}
+}
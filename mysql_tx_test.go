@@ -0,0 +1,64 @@
+package mcommon_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/moremorefun/mcommon"
+	"github.com/moremorefun/mcommon/dbtest"
+)
+
+func TestDbTransactionRetry(t *testing.T) {
+	for _, number := range []uint16{mcommon.DbErrLockDeadlock, mcommon.DbErrLockWaitTimeout} {
+		db := dbtest.New()
+		db.Stub(`^UPDATE t_a`).WillReturnError(&mysql.MySQLError{Number: number}).Times(1)
+		db.Stub(`^UPDATE t_a`).WillReturnResult(0, 1)
+
+		err := mcommon.DbTransactionRetry(context.Background(), db, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+			_, err := dbTx.ExecContext(ctx, "UPDATE t_a SET n=1")
+			return err
+		}, mcommon.DbTxWithBackoff(0, 0))
+		if err != nil {
+			t.Fatalf("%d: %s", number, err)
+		}
+		want := []string{
+			"BEGIN",
+			"UPDATE t_a SET n=1",
+			"ROLLBACK",
+			"BEGIN",
+			"UPDATE t_a SET n=1",
+			"COMMIT",
+		}
+		if queries := testQueries(db); !reflect.DeepEqual(queries, want) {
+			t.Fatalf("%d queries: %q", number, queries)
+		}
+	}
+}
+
+func TestDbTransactionRetryInTx(t *testing.T) {
+	db := dbtest.New()
+	db.Stub(`^UPDATE t_a`).WillReturnError(&mysql.MySQLError{Number: mcommon.DbErrLockDeadlock})
+
+	err := mcommon.DbTransactionContext(context.Background(), db, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+		// 已在事物中时不重试 由最外层事物处理
+		return mcommon.DbTransactionRetry(ctx, db, func(ctx context.Context, dbTx mcommon.DbExeAble) error {
+			_, err := dbTx.ExecContext(ctx, "UPDATE t_a SET n=1")
+			return err
+		}, mcommon.DbTxWithBackoff(0, 0))
+	})
+	if !mcommon.DbIsRetryableErr(err) {
+		t.Fatalf("err: %v", err)
+	}
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT mcommon_sp",
+		"UPDATE t_a SET n=1",
+		"ROLLBACK TO SAVEPOINT mcommon_sp",
+		"ROLLBACK",
+	}
+	if queries := testQueries(db); !reflect.DeepEqual(queries, want) {
+		t.Fatalf("queries: %q", queries)
+	}
+}
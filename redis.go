@@ -32,9 +32,14 @@ func RedisSetBaseKey(v string) {
 	baseKey = v
 }
 
+// redisKey 添加基础key前缀
+func redisKey(key string) string {
+	return fmt.Sprintf("%s_%s", baseKey, key)
+}
+
 // RedisGet 获取
 func RedisGet(ctx context.Context, client *redis.Client, key string) (string, error) {
-	key = fmt.Sprintf("%s_%s", baseKey, key)
+	key = redisKey(key)
 	ret, err := client.WithContext(ctx).Get(key).Result()
 	if err != nil {
 		// "redis: nil" 不存在
@@ -48,7 +53,7 @@ func RedisGet(ctx context.Context, client *redis.Client, key string) (string, er
 
 // RedisSet 设置
 func RedisSet(ctx context.Context, client *redis.Client, key, value string, du time.Duration) error {
-	key = fmt.Sprintf("%s_%s", baseKey, key)
+	key = redisKey(key)
 	err := client.WithContext(ctx).Set(key, value, du).Err()
 	if err != nil {
 		return err
@@ -58,7 +63,7 @@ func RedisSet(ctx context.Context, client *redis.Client, key, value string, du t
 
 // RedisRm 删除
 func RedisRm(ctx context.Context, client *redis.Client, key string) error {
-	key = fmt.Sprintf("%s_%s", baseKey, key)
+	key = redisKey(key)
 	err := client.WithContext(ctx).Del(key).Err()
 	if err != nil {
 		return err
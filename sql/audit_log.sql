@@ -0,0 +1,13 @@
+CREATE TABLE `t_audit_log` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `table_name` varchar(128) NOT NULL DEFAULT '' COMMENT '表名',
+  `pk` varchar(128) NOT NULL DEFAULT '' COMMENT '主键',
+  `action` varchar(16) NOT NULL DEFAULT '' COMMENT 'update delete',
+  `actor` varchar(128) NOT NULL DEFAULT '' COMMENT '操作者',
+  `request_id` varchar(128) NOT NULL DEFAULT '' COMMENT '请求id',
+  `before_data` mediumtext NOT NULL COMMENT '修改前 json',
+  `after_data` mediumtext NOT NULL COMMENT '修改后 json 删除时为null',
+  `created_at` bigint(20) NOT NULL DEFAULT '0' COMMENT '创建时间 毫秒',
+  PRIMARY KEY (`id`),
+  KEY `idx_table_name_pk` (`table_name`,`pk`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='审计日志';
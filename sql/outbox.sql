@@ -0,0 +1,13 @@
+CREATE TABLE `t_outbox` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `topic` varchar(128) NOT NULL DEFAULT '' COMMENT '主题',
+  `payload` mediumtext NOT NULL COMMENT '内容',
+  `status` tinyint(4) NOT NULL DEFAULT '0' COMMENT '0 未发送 1 已发送 2 发送失败',
+  `retry_count` int(11) NOT NULL DEFAULT '0' COMMENT '重试次数',
+  `next_at` bigint(20) NOT NULL DEFAULT '0' COMMENT '下次发送时间 毫秒',
+  `last_error` varchar(512) NOT NULL DEFAULT '' COMMENT '最后一次发送错误',
+  `created_at` bigint(20) NOT NULL DEFAULT '0' COMMENT '创建时间 毫秒',
+  `sent_at` bigint(20) NOT NULL DEFAULT '0' COMMENT '发送时间 毫秒',
+  PRIMARY KEY (`id`),
+  KEY `idx_status_next_at` (`status`,`next_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='事物发件箱';